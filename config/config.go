@@ -3,7 +3,9 @@ package config
 import (
 	"errors"
 	"os"
+	"strings"
 
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v2"
 )
 
@@ -11,19 +13,89 @@ type Config struct {
 	path                 string
 	SkiplistMaxHeight    int
 	BtreeDegree          int
-	MemtableCap          int
-	MemtableContainer    string
+	MemtableCap          int    // 0 znaci da broj kljuceva u memtabeli nije ogranicen
+	MemtableMaxBytes     int64  // mora biti veci od 0, memtabela se prazni kada velicina kljuceva i vrednosti dostigne ovu
+	MemtableContainer    string // skiplist, concurrent-skiplist ili btree, operacije nad memtabelom se i sa concurrent-skiplist izvrsavaju jedna po jedna
 	SSTableAllInOne      bool
 	FilterPrecision      float64
 	SummaryStep          int
-	CacheSize            int
-	WalLowWaterMark      int
+	CacheSize            int   // 0 znaci da broj kljuceva u kesu nije ogranicen
+	CacheMaxBytes        int64 // mora biti veci od 0
+	CachePolicy          string
 	WalSegmentSize       int64
+	WalSyncPolicy        string
+	WalSyncInterval      int64
 	TBucketResetDuration int64
 	TBucketMaxTokens     int
 	LsmMaxLevel          int
 	LsmLevelSize         int
 	MerkleChunkSize      int
+	CmsEpsilon           float64
+	CmsDelta             float64
+	HllPrecision         int
+	MergeOperator        string
+	ColumnFamilies       []FamilyConfig
+	RateLimits           []RateLimitConfig
+	OperationCosts       map[string]int
+}
+
+// Ogranicenje zahteva jednog klijenta, klijenti bez ogranicenja koriste TBucketMaxTokens i TBucketResetDuration
+type RateLimitConfig struct {
+	Client        string
+	MaxTokens     int
+	ResetDuration int64
+}
+
+// Broj tokena koji operacija kosta, operacije koje nisu navedene kostaju jedan token
+func (c *Config) OperationCost(op string) int {
+	if cost, ok := c.OperationCosts[op]; ok {
+		return cost
+	}
+	return 1
+}
+
+// Ogranicenje zahteva klijenta iz config-a ili podrazumevano
+func (c *Config) RateLimit(client string) RateLimitConfig {
+	for _, l := range c.RateLimits {
+		if l.Client == client {
+			return l
+		}
+	}
+	return RateLimitConfig{Client: client, MaxTokens: c.TBucketMaxTokens, ResetDuration: c.TBucketResetDuration}
+}
+
+// Familija kolona ima sopstvenu memtabelu i nivoe, nulte vrednosti polja se preuzimaju iz osnovnog config-a
+// Id se upisuje u WAL i ne sme se menjati, podrazumevana familija ima Id 0
+type FamilyConfig struct {
+	Name              string
+	Id                uint32
+	MemtableContainer string
+	MemtableCap       int
+	MemtableMaxBytes  int64
+	FilterPrecision   float64
+}
+
+const DefaultFamily = "default"
+
+var memtableContainers = []string{"skiplist", "concurrent-skiplist", "btree"}
+
+// Config familije kolona, osnovni config sa vrednostima koje familija menja
+func (c *Config) ForFamily(f FamilyConfig) *Config {
+	family := *c
+	family.ColumnFamilies = nil
+	if f.MemtableContainer != "" {
+		family.MemtableContainer = f.MemtableContainer
+	}
+	if f.MemtableCap != 0 {
+		family.MemtableCap = f.MemtableCap
+	}
+	if f.MemtableMaxBytes != 0 {
+		family.MemtableMaxBytes = f.MemtableMaxBytes
+	}
+	if f.FilterPrecision != 0 {
+		family.FilterPrecision = f.FilterPrecision
+	}
+	return &family
 }
 
 func (c Config) Save() {
@@ -32,7 +104,7 @@ func (c Config) Save() {
 }
 
 func tryLoad(path string) (*Config, bool) {
-	c := Config{}
+	c := *GetDefault()
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, false
@@ -47,11 +119,9 @@ func validConfig(c *Config) error {
 	ints := []int64{
 		int64(c.SkiplistMaxHeight),
 		int64(c.BtreeDegree),
-		int64(c.MemtableCap),
 		int64(c.SummaryStep),
-		int64(c.CacheSize),
-		int64(c.WalLowWaterMark),
 		c.WalSegmentSize,
+		c.WalSyncInterval,
 		c.TBucketResetDuration,
 		int64(c.TBucketMaxTokens),
 		int64(c.LsmMaxLevel),
@@ -63,12 +133,85 @@ func validConfig(c *Config) error {
 			return errors.New(err_message + "(negativan broj)")
 		}
 	}
+	// 0 bi znacilo da je memtabela uvek puna, odnosno da je kes uvek prazan
+	if c.MemtableMaxBytes <= 0 {
+		return errors.New(err_message + "(MemtableMaxBytes mora biti veci od 0)")
+	}
+	if c.CacheMaxBytes <= 0 {
+		return errors.New(err_message + "(CacheMaxBytes mora biti veci od 0)")
+	}
+	if c.MemtableCap < 0 || c.CacheSize < 0 {
+		return errors.New(err_message + "(negativan broj)")
+	}
+	if c.WalSegmentSize < 64 {
+		return errors.New(err_message + "(WalSegmentSize)")
+	}
 	if c.FilterPrecision <= 0 || c.FilterPrecision >= 1 {
 		return errors.New(err_message + "(FilterPrecision)")
 	}
-	if c.MemtableContainer != "skiplist" && c.MemtableContainer != "btree" {
+	if c.CmsEpsilon <= 0 || c.CmsEpsilon >= 1 {
+		return errors.New(err_message + "(CmsEpsilon)")
+	}
+	if c.CmsDelta <= 0 || c.CmsDelta >= 1 {
+		return errors.New(err_message + "(CmsDelta)")
+	}
+	if c.HllPrecision < 4 || c.HllPrecision > 16 {
+		return errors.New(err_message + "(HllPrecision)")
+	}
+	if !slices.Contains(memtableContainers, c.MemtableContainer) {
 		return errors.New(err_message + "(MemtableContainer)")
 	}
+	if !slices.Contains([]string{"lru", "lfu", "arc", "w-tinylfu"}, c.CachePolicy) {
+		return errors.New(err_message + "(CachePolicy)")
+	}
+	if c.MergeOperator == "" {
+		return errors.New(err_message + "(MergeOperator)")
+	}
+	if !slices.Contains([]string{"none", "always", "group", "periodic"}, c.WalSyncPolicy) {
+		return errors.New(err_message + "(WalSyncPolicy)")
+	}
+	for op, cost := range c.OperationCosts {
+		if cost <= 0 {
+			return errors.New(err_message + "(OperationCosts: " + op + ")")
+		}
+	}
+	clients := []string{}
+	for _, l := range c.RateLimits {
+		if l.Client == "" || slices.Contains(clients, l.Client) || l.MaxTokens <= 0 || l.ResetDuration <= 0 {
+			return errors.New(err_message + "(RateLimits: " + l.Client + ")")
+		}
+		clients = append(clients, l.Client)
+	}
+	return validFamilies(c.ColumnFamilies)
+}
+
+func validFamilies(families []FamilyConfig) error {
+	err_message := "neispravan config fajl (ColumnFamilies): "
+	names := []string{DefaultFamily}
+	ids := []uint32{0}
+	for _, f := range families {
+		if f.Name == "" || strings.ContainsAny(f.Name, `/\.`) {
+			return errors.New(err_message + "neispravan naziv " + f.Name)
+		}
+		if slices.Contains(names, f.Name) || slices.Contains(ids, f.Id) {
+			return errors.New(err_message + "naziv i id moraju biti jedinstveni (" + f.Name + ")")
+		}
+		names = append(names, f.Name)
+		ids = append(ids, f.Id)
+
+		if f.MemtableContainer != "" && !slices.Contains(memtableContainers, f.MemtableContainer) {
+			return errors.New(err_message + "MemtableContainer familije " + f.Name)
+		}
+		if f.MemtableCap < 0 {
+			return errors.New(err_message + "MemtableCap familije " + f.Name)
+		}
+		if f.MemtableMaxBytes < 0 {
+			return errors.New(err_message + "MemtableMaxBytes familije " + f.Name)
+		}
+		if f.FilterPrecision < 0 || f.FilterPrecision >= 1 {
+			return errors.New(err_message + "FilterPrecision familije " + f.Name)
+		}
+	}
 	return nil
 }
 
@@ -76,19 +219,35 @@ func GetDefault() *Config {
 	return &Config{
 		SkiplistMaxHeight:    10,
 		BtreeDegree:          4,
-		MemtableCap:          3,
+		MemtableCap:          0,
+		MemtableMaxBytes:     64,
 		MemtableContainer:    "btree",
 		SSTableAllInOne:      true,
 		FilterPrecision:      0.01,
 		SummaryStep:          5,
-		CacheSize:            4,
-		WalLowWaterMark:      5,
+		CacheSize:            0,
+		CacheMaxBytes:        1024,
+		CachePolicy:          "lru",
 		WalSegmentSize:       256,
+		WalSyncPolicy:        "always",
+		WalSyncInterval:      100,
 		TBucketResetDuration: 7000,
 		TBucketMaxTokens:     5,
 		LsmMaxLevel:          4,
 		LsmLevelSize:         2,
 		MerkleChunkSize:      100,
+		CmsEpsilon:           0.01,
+		CmsDelta:             0.01,
+		HllPrecision:         10,
+		MergeOperator:        "add",
+		OperationCosts: map[string]int{
+			"get":        1,
+			"put":        1,
+			"delete":     1,
+			"merge":      1,
+			"scan":       3,
+			"compaction": 5,
+		},
 	}
 }
 
@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestValidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		valid  bool
+	}{
+		{"podrazumevani", func(c *Config) {}, true},
+		{"bez ogranicenja broja kljuceva", func(c *Config) { c.MemtableCap, c.CacheSize = 0, 0 }, true},
+		{"MemtableMaxBytes 0", func(c *Config) { c.MemtableMaxBytes = 0 }, false},
+		{"CacheMaxBytes 0", func(c *Config) { c.CacheMaxBytes = 0 }, false},
+		{"negativan MemtableCap", func(c *Config) { c.MemtableCap = -1 }, false},
+		{"nepoznata politika kesa", func(c *Config) { c.CachePolicy = "fifo" }, false},
+	}
+	for _, test := range tests {
+		c := GetDefault()
+		test.change(c)
+		if err := validConfig(c); (err == nil) != test.valid {
+			t.Errorf("%s: validConfig = %v", test.name, err)
+		}
+	}
+}
@@ -1,17 +1,27 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	conf "go-touch-grass/config"
 	"go-touch-grass/internal/cache"
 	"go-touch-grass/internal/lsmtree"
-	"go-touch-grass/internal/tbucket"
+	"go-touch-grass/internal/merge"
+	"go-touch-grass/internal/merkle"
+	"go-touch-grass/internal/sstable"
 	"go-touch-grass/internal/wal"
-	"math"
+	"io"
 	"os"
 	fp "path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/exp/slices"
 )
 
 type App struct {
@@ -20,130 +30,478 @@ type App struct {
 	cache    *cache.Cache
 	wal      *wal.WAL
 	lsm      *lsmtree.LSMTree
-	tbucket  *tbucket.TBucket
+	limiter  *limiter
+	mu       *sync.Mutex // operacije nad bazom se izvrsavaju jedna po jedna
+
+	client string          // klijent cije se ogranicenje zahteva koristi
+	ctx    context.Context // ako je zadat, operacija ceka na tokene umesto da vrati gresku
+
+	family   uint32 // familija kolona nad kojom se izvrsavaju operacije, lsm i cache su njeni
+	families map[uint32]*family
 }
 
+type family struct {
+	name  string
+	cache *cache.Cache
+	lsm   *lsmtree.LSMTree
+}
+
+// Pokrece aplikaciju i ponovo primenjuje izmene iz WAL-a koje nisu u SSTabelama
 func New() (*App, error) {
+	return NewWithProgress(nil)
+}
+
+// Kao New, progress dobija broj procitanih segmenata i primenjenih izmena tokom oporavka iz WAL-a
+// Upisi nisu moguci pre kraja oporavka, jer bi praznjenje memtabele uklonilo segmente sa neprimenjenim izmenama
+func NewWithProgress(progress func(segments int, records int)) (*App, error) {
 	config, err := conf.New(getConfigPath())
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err = merge.Get(config.MergeOperator); err != nil {
+		return nil, err
+	}
+
+	log, err := wal.New(getWalPath(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cache.NewWithPolicy(config.CachePolicy, config.CacheSize, config.CacheMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	families := map[uint32]*family{
+		0: {conf.DefaultFamily, c, lsmtree.New(config, getDataPath())},
+	}
+	for _, f := range config.ColumnFamilies {
+		c, err := cache.NewWithPolicy(config.CachePolicy, config.CacheSize, config.CacheMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		// nivoi familije su u posebnom direktorijumu unutar direktorijuma podataka
+		path := fp.Join(getDataPath(), familyDir(f.Name))
+		families[f.Id] = &family{f.Name, c, lsmtree.New(config.ForFamily(f), path)}
+	}
+
 	app := &App{
 		datapath: getDataPath(),
 		config:   config,
-		cache:    cache.New(config.CacheSize),
-		wal:      wal.New(getWalPath(), config),
-		lsm:      lsmtree.New(config, getDataPath()),
-		tbucket:  tbucket.New(config),
+		cache:    families[0].cache,
+		wal:      log,
+		lsm:      families[0].lsm,
+		limiter:  newLimiter(),
+		mu:       &sync.Mutex{},
+		families: families,
 	}
-	app.StartRecovery()
+	if err = app.replayWal(progress); err != nil {
+		app.Close()
+		return nil, err
+	}
+	if err = app.loadBuckets(); err != nil {
+		app.Close()
+		return nil, err
+	}
+	go app.saveBucketsPeriodically()
 	return app, nil
 }
 
-func (app *App) UnlockTokenBucket() {
-	app.tbucket = tbucket.New(&conf.Config{
-		TBucketResetDuration: math.MaxInt64,
-		TBucketMaxTokens:     math.MaxInt,
-	})
+// Aplikacija cije se operacije izvrsavaju nad familijom kolona name
+// Sve familije dele WAL, token bucket i redosled izvrsavanja operacija
+func (app *App) ColumnFamily(name string) (*App, error) {
+	for id, f := range app.families {
+		if f.name == name {
+			cf := *app
+			cf.family, cf.lsm, cf.cache = id, f.lsm, f.cache
+			return &cf, nil
+		}
+	}
+	return nil, errors.New("familija kolona " + name + " ne postoji")
 }
 
-func (app *App) CanMakeQuery() error {
-	return app.tbucket.MakeQuery()
+// Nazivi svih familija kolona, podrazumevana je prva
+func (app *App) ColumnFamilies() []string {
+	names := []string{conf.DefaultFamily}
+	for _, f := range app.config.ColumnFamilies {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func (app *App) FamilyName() string {
+	return app.families[app.family].name
 }
 
-func (app *App) StartRecovery() (err error) {
-	recovery_log, err := app.wal.Recover()
+// Rezervisani kljucevi, pod njima se cuva stanje token bucket-a
+const internalPrefix = "__internal__"
+
+// Prefiksi kljuceva koje korisnicke operacije ne mogu da citaju ni menjaju, pod njima su interni podaci i tipovi podataka
+var reservedPrefixes = []string{internalPrefix, cmsPrefix, hllPrefix, simhashPrefix}
+
+func checkKey(key string) error {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return errors.New("kljucevi sa prefiksom " + prefix + " su rezervisani")
+		}
+	}
+	return nil
+}
+
+// Ponovo primenjuje izmene iz WAL-a koje nisu upisane u SSTabele svojih familija kolona
+// Zapisi se citaju jedan po jedan, progress dobija broj procitanih segmenata i primenjenih izmena
+func (app *App) replayWal(progress func(segments int, records int)) error {
+	reader, err := app.wal.NewReader(app.wal.FlushPosition())
 	if err != nil {
-		return
+		return err
+	}
+
+	// poslednja primenjena sekvenca svake familije
+	last := make(map[uint32]uint64)
+	// familije cija je memtabela ispraznjena tokom oporavka, oznake praznjenja se upisuju tek posle oporavka
+	// jer bi citac inace procitao novu oznaku na kraju WAL-a i obrisao izmene primenjene posle praznjenja
+	flushed := make(map[uint32]bool)
+	segments, records := 0, 0
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if reader.Segments() != segments && progress != nil {
+			segments = reader.Segments()
+			progress(segments, records)
+		}
+
+		if record.Type == wal.FlushRecord {
+			f, err := app.getFamily(record.Family)
+			if err != nil {
+				return err
+			}
+			// izmene familije pre oznake su vec u SSTabelama
+			f.lsm.ClearMemtable()
+			continue
+		}
+		changes, err := record.Unbatch()
+		if err != nil {
+			return err
+		}
+		for _, v := range changes {
+			f, err := app.getFamily(v.Family)
+			if err != nil {
+				return err
+			}
+			if f.lsm.MemtableFull() {
+				if err = f.lsm.FlushMemtable(); err != nil {
+					return err
+				}
+				flushed[v.Family] = true
+			}
+			if v.Type == wal.MergeRecord {
+				err = f.lsm.ReplayMerge(string(v.Key), v.Value, v.Seq)
+			} else {
+				err = f.lsm.Replay(string(v.Key), v.Value, v.Type == wal.DeleteRecord, v.Expires, v.Seq)
+			}
+			if err != nil {
+				return err
+			}
+			last[v.Family] = v.Seq
+			records++
+		}
 	}
-	if recovery_log == nil {
-		return nil
+
+	if progress != nil {
+		progress(reader.Segments(), records)
 	}
-	for _, v := range recovery_log {
-		if v.Tombstone {
-			app.lsm.Delete(string(v.Key))
-		} else {
-			app.lsm.Put(string(v.Key), v.Value)
+	// oznaka praznjenja vazi za sve zapise pre nje u WAL-u, pa se prazni i ostatak memtabele
+	// familije ispraznjene tokom oporavka, cije su izmene u WAL-u pre oznake
+	for _, id := range app.familyIds() {
+		f := app.families[id]
+		if !flushed[id] && !f.lsm.MemtableFull() {
+			continue
+		}
+		if _, ok := f.lsm.OldestSeq(); ok {
+			if err = f.lsm.FlushMemtable(); err != nil {
+				return err
+			}
+		}
+		if err = app.markFlushed(id, last[id]); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// Direktorijum familije kolona u odnosu na direktorijum podataka, podrazumevana familija je u njemu samom
+func familyDir(name string) string {
+	if name == conf.DefaultFamily {
+		return ""
+	}
+	return "cf-" + name
+}
+
+// Identifikatori svih familija kolona u rastucem redosledu
+func (app *App) familyIds() []uint32 {
+	ids := make([]uint32, 0, len(app.families))
+	for id := range app.families {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (app *App) getFamily(id uint32) (*family, error) {
+	f, ok := app.families[id]
+	if !ok {
+		return nil, fmt.Errorf("WAL sadrzi izmene familije kolona %d koja nije u config fajlu", id)
+	}
+	return f, nil
+}
+
+// Oznacava da su izmene familije do seq u SSTabelama i uklanja segmente WAL-a koji nisu potrebni nijednoj familiji
+func (app *App) markFlushed(id uint32, seq uint64) error {
+	if err := app.wal.MarkFlushed(id, seq); err != nil {
+		return err
+	}
+	persisted := seq
+	for _, f := range app.families {
+		if oldest, ok := f.lsm.OldestSeq(); ok && oldest <= persisted {
+			persisted = max(oldest, 1) - 1
+		}
+	}
+	return app.wal.Reclaim(persisted)
+}
+
+// Zakljucava app.mu za operaciju koja menja bazu, vraca poziciju kraja WAL-a pre operacije
+func (app *App) lockWrite() uint64 {
+	app.mu.Lock()
+	return app.wal.Written()
+}
+
+// Otpusta app.mu pa tek onda ceka da izmene operacije budu na disku, kako bi jedan fsync
+// grupnog commit-a (WalSyncPolicy group) obuhvatio upise vise klijenata
+// Izmena je vidljiva drugim operacijama pre nego sto se operacija koja ju je upisala vrati
+func (app *App) unlockWrite(start uint64, err *error) {
+	end := app.wal.Written()
+	app.mu.Unlock()
+	if end == start {
+		return
+	}
+	if serr := app.wal.WaitSynced(end); *err == nil {
+		*err = serr
+	}
+}
+
 func (app *App) Put(key string, data []byte) (err error) {
-	err = app.tbucket.MakeQuery()
+	defer app.unlockWrite(app.lockWrite(), &err)
+	if err = checkKey(key); err != nil {
+		return
+	}
+	err = app.takeToken(opPut)
 	if err != nil {
 		return
 	}
+	return app.put(key, data)
+}
 
-	wal_record := wal.NewRecord(time.Now(), false, []byte(key), data)
-	err = app.wal.WriteRecord(*wal_record)
+// Upis vrednosti koja posle isteka ttl vise nije vidljiva
+func (app *App) PutWithTTL(key string, data []byte, ttl time.Duration) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	if ttl <= 0 {
+		return errors.New("vreme trajanja mora biti pozitivno")
+	}
+	if err = checkKey(key); err != nil {
+		return
+	}
+	err = app.takeToken(opPut)
 	if err != nil {
 		return
 	}
+	return app.putWithExpiry(key, data, time.Now().Add(ttl))
+}
 
-	err, flushed := app.lsm.Put(key, data)
-	if flushed {
-		app.wal.WriteRecord(wal.Record{
-			Timestamp: time.Now(),
-			FlushFlag: true,
-		})
-		app.cache.Clear()
+func (app *App) Get(key string) (data []byte, err error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if err = checkKey(key); err != nil {
+		return
+	}
+	err = app.takeToken(opGet)
+	if err != nil {
+		return
+	}
+	return app.get(key)
+}
+
+func (app *App) Delete(key string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	if err = checkKey(key); err != nil {
+		return
+	}
+	err = app.takeToken(opDelete)
+	if err != nil {
+		return
+	}
+	return app.delete(key)
+}
+
+// Upisuje novu vrednost samo ako je trenutna jednaka expected, nil expected znaci da kljuc ne postoji
+// Provera i upis se izvrsavaju atomicno, u WAL se upisuje samo novi podatak
+func (app *App) CompareAndSwap(key string, expected []byte, data []byte) (swapped bool, err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	if err = checkKey(key); err != nil {
+		return
+	}
+	err = app.takeToken(opPut)
+	if err != nil {
+		return
+	}
+
+	current, err := app.get(key)
+	if err != nil {
+		return
+	}
+	if (current == nil) != (expected == nil) || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	return true, app.put(key, data)
+}
+
+// Upisuje vrednost samo ako kljuc ne postoji ili je obrisan
+func (app *App) PutIfAbsent(key string, data []byte) (bool, error) {
+	return app.CompareAndSwap(key, nil, data)
+}
+
+// Izmena koja se spaja sa trenutnom vrednoscu kljuca operatorom iz config fajla (MergeOperator)
+// Vrednost se ne cita pri upisu, izmene se spajaju pri citanju i kompakciji
+func (app *App) Merge(key string, operand []byte) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	if err = checkKey(key); err != nil {
+		return
+	}
+	err = app.takeToken(opMerge)
+	if err != nil {
+		return
+	}
+
+	// neispravna izmena se odbija pre upisa, da ne bi kasnije onemogucila citanje kljuca
+	if _, err = app.lsm.ApplyMerge(nil, [][]byte{operand}); err != nil {
+		return
+	}
+	wal_record := wal.NewRecord(wal.MergeRecord, []byte(key), operand)
+	wal_record.Family = app.family
+	_, err = app.wal.AppendRecord(wal_record)
+	if err != nil {
+		return
+	}
+
+	err, flushed := app.lsm.Merge(key, operand, wal_record.Seq)
+	if err == nil {
+		app.mergeCached(key, operand)
+	}
+	if flushed && err == nil {
+		err = app.markFlushed(app.family, wal_record.Seq)
 	}
 	return
 }
 
-func (app *App) Get(key string) (data []byte, err error) {
-	err = app.tbucket.MakeQuery()
+// Izmena se spaja i sa vrednoscu u kesu, ako spajanje ne uspe kljuc se izbacuje iz kesa
+func (app *App) mergeCached(key string, operand []byte) {
+	cached := app.cache.Peek(key)
+	if cached == nil {
+		// kljuc za koji je zapamceno da ne postoji sada ima vrednost
+		app.cache.Remove(key)
+		return
+	}
+	value, err := app.lsm.ApplyMerge(cached, [][]byte{operand})
 	if err != nil {
+		app.cache.Remove(key)
 		return
 	}
+	app.cache.Update(key, value, time.Time{})
+}
+
+func (app *App) put(key string, data []byte) (err error) {
+	return app.putWithExpiry(key, data, time.Time{})
+}
 
-	data, deleted := app.lsm.GetFromMemtable(key)
+func (app *App) putWithExpiry(key string, data []byte, expires time.Time) (err error) {
+	wal_record := wal.NewExpiringRecord([]byte(key), data, expires)
+	wal_record.Family = app.family
+	_, err = app.wal.AppendRecord(wal_record)
+	if err != nil {
+		return
+	}
+
+	err, flushed := app.lsm.Put(key, data, expires, wal_record.Seq)
+	if err == nil {
+		app.cache.Update(key, data, expires)
+	}
+	if flushed && err == nil {
+		err = app.markFlushed(app.family, wal_record.Seq)
+	}
+	return
+}
+
+// Kes sadrzi trenutne vrednosti kljuceva, ukljucujuci izmene iz memtabele
+func (app *App) get(key string) (data []byte, err error) {
+	data, deleted, operands := app.lsm.GetFromMemtable(key)
 	if data != nil || deleted {
 		return
 	}
 
 	// Check if in cache
-	data = app.cache.Get(key)
-	if data != nil {
+	data, missing := app.cache.Get(key)
+	if data != nil || missing {
 		return
 	}
 
-	data, err = app.lsm.GetFromDisc(key)
+	data, expires, err := app.lsm.GetFromDisc(key)
 	if err != nil {
 		return
 	}
+	if operands != nil {
+		// izmene iz memtabele se spajaju sa vrednoscu sa diska
+		data, err = app.lsm.ApplyMerge(data, operands)
+		if err != nil {
+			return
+		}
+		expires = time.Time{}
+	}
 	if data != nil {
-		app.cache.Add(key, data)
+		app.cache.Add(key, data, expires)
+	} else {
+		// nepostojeci i obrisani kljucevi se pamte da bi se izbegla ponovna pretraga svih nivoa
+		app.cache.AddMissing(key)
 	}
 	return
 }
 
-func (app *App) Delete(key string) (err error) {
-	err = app.tbucket.MakeQuery()
+func (app *App) delete(key string) (err error) {
+	wal_record := wal.NewRecord(wal.DeleteRecord, []byte(key), nil)
+	wal_record.Family = app.family
+	_, err = app.wal.AppendRecord(wal_record)
 	if err != nil {
 		return
 	}
 
-	wal_record := wal.NewRecord(time.Now(), true, []byte(key), nil)
-	err = app.wal.WriteRecord(*wal_record)
-	if err != nil {
-		return
+	err, flushed := app.lsm.Delete(key, wal_record.Seq)
+	if err == nil {
+		app.cache.Remove(key)
 	}
-
-	err, flushed := app.lsm.Delete(key)
-	if flushed {
-		app.wal.WriteRecord(wal.Record{
-			Timestamp: time.Now(),
-			FlushFlag: true,
-		})
-		app.cache.Clear()
+	if flushed && err == nil {
+		err = app.markFlushed(app.family, wal_record.Seq)
 	}
 	return
 }
 
 func (app *App) InitiateCompaction(level int) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if err := app.takeToken(opCompaction); err != nil {
+		return err
+	}
 	if app.lsm.LevelEmpty(level) {
 		return errors.New("uneti nivo je prazan (nema SSTabela za kompakciju)")
 	} else if level > app.lsm.LevelCount() {
@@ -155,8 +513,89 @@ func (app *App) InitiateCompaction(level int) error {
 	return app.lsm.CompactLevel(level)
 }
 
-func (app *App) CleanupWal() {
-	app.wal.CleanUpWal()
+func (app *App) VerifyIntegrity() ([]sstable.IntegrityReport, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if err := app.takeToken(opScan); err != nil {
+		return nil, err
+	}
+	// proveravaju se SSTabele svih familija kolona, a ne samo izabrane
+	reports := make([]sstable.IntegrityReport, 0)
+	for _, id := range app.familyIds() {
+		r, err := app.families[id].lsm.VerifyIntegrity()
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r...)
+	}
+	return reports, nil
+}
+
+func (app *App) CompareDataDir(path string) ([]sstable.DiffReport, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if err := app.takeToken(opScan); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.New("direktorijum " + path + " ne postoji")
+	}
+	reports := make([]sstable.DiffReport, 0)
+	for _, id := range app.familyIds() {
+		f := app.families[id]
+		dir := familyDir(f.name)
+		r, err := f.lsm.CompareWith(fp.Join(path, dir))
+		if err != nil {
+			return nil, err
+		}
+		for i := range r {
+			r[i].Table = fp.ToSlash(fp.Join(dir, r[i].Table))
+		}
+		reports = append(reports, r...)
+	}
+	return reports, nil
+}
+
+func (app *App) DatabaseHash() (string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if err := app.takeToken(opScan); err != nil {
+		return "", err
+	}
+	// koren Merkle stabla nad korenima SSTabela svih familija kolona, redom po id-u familije
+	leafs := make([]*merkle.Node, 0)
+	for _, id := range app.familyIds() {
+		hashes, err := app.families[id].lsm.TableHashes()
+		if err != nil {
+			return "", err
+		}
+		leafs = append(leafs, hashes...)
+	}
+	return hex.EncodeToString(merkle.NewMerkleTree(leafs, 0).RootHash()), nil
+}
+
+// Broj pogodaka i promasaja kesa izabrane familije, za poredjenje politika kesa
+func (app *App) CacheStats() cache.Stats {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.cache.Stats()
+}
+
+// Cuva stanje token bucket-a i zatvara WAL
+func (app *App) Close() error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.limiter.stopped.Do(func() { close(app.limiter.stop) })
+	err := app.saveBuckets()
+	if werr := app.wal.Close(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// Pregled zapisa iz WAL-a, ne zahteva pokrenutu aplikaciju
+func InspectWal(filter wal.Filter, visit func(wal.Entry) error) error {
+	return wal.Inspect(getWalPath(), filter, visit)
 }
 
 func getConfigPath() string {
@@ -0,0 +1,208 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"go-touch-grass/internal/tbucket"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Putanje config fajla, podataka i WAL-a su relativne, pa se test izvrsava u privremenom direktorijumu
+// config sadrzi samo vrednosti koje se razlikuju od podrazumevanih
+func testDir(t testing.TB, config string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	os.Mkdir("config", 0755)
+	if err = os.WriteFile("config/config.yaml", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openTestApp(t testing.TB) *App {
+	app, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// Zaustavlja aplikaciju bez cuvanja stanja, kao pri padu procesa
+func crash(app *App) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.limiter.stopped.Do(func() { close(app.limiter.stop) })
+	app.wal.Close()
+}
+
+func TestTokenBucketAfterCrash(t *testing.T) {
+	testDir(t, "tbucketmaxtokens: 3\ntbucketresetduration: 1000000\n")
+	app := openTestApp(t)
+	for i := 0; i < 3; i++ {
+		if err := app.Put("a", []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(tbucketSaveInterval + 200*time.Millisecond)
+	crash(app)
+
+	app = openTestApp(t)
+	defer app.Close()
+	if err := app.Put("a", []byte("a")); !errors.Is(err, tbucket.ErrTooManyRequests) {
+		t.Errorf("posle pada su tokeni ponovo dostupni: %v", err)
+	}
+}
+
+// Memtabela manja nego pri upisu se prazni tokom oporavka, izmene primenjene posle praznjenja ne smeju se izgubiti
+func TestReplayFlush(t *testing.T) {
+	testDir(t, "memtablemaxbytes: 100000\n")
+	app := openTestApp(t)
+	app.UnlockTokenBucket()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if err := app.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crash(app)
+
+	if err := os.WriteFile("config/config.yaml", []byte("memtablemaxbytes: 200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// drugo pokretanje cita oznake praznjenja upisane pri prvom oporavku
+	for run := 0; run < 2; run++ {
+		app = openTestApp(t)
+		app.UnlockTokenBucket()
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("key-%02d", i)
+			if data, err := app.Get(key); err != nil || string(data) != key {
+				t.Fatalf("pokretanje %d: Get(%s) = %q, %v", run+1, key, data, err)
+			}
+		}
+		if err := app.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Korisnicke operacije ne smeju da citaju ni menjaju kljuceve pod kojima su sacuvani tipovi podataka
+func TestReservedPrefix(t *testing.T) {
+	testDir(t, "")
+	app := openTestApp(t)
+	defer app.Close()
+	app.UnlockTokenBucket()
+	if err := app.CreateCMS("x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.CreateHLL("x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.PutSimHash("x", "tekst"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{tbucketKey, cmsPrefix + "x", hllPrefix + "x", simhashPrefix + "x"} {
+		if err := app.Put(key, []byte("x")); err == nil {
+			t.Errorf("Put(%s) nije odbijen", key)
+		}
+		if _, err := app.Get(key); err == nil {
+			t.Errorf("Get(%s) nije odbijen", key)
+		}
+		if err := app.Delete(key); err == nil {
+			t.Errorf("Delete(%s) nije odbijen", key)
+		}
+		if err := app.Merge(key, []byte("1")); err == nil {
+			t.Errorf("Merge(%s) nije odbijen", key)
+		}
+	}
+
+	if err := app.AddToCMS("x", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := app.EstimateCMS("x", "a"); err != nil || count != 1 {
+		t.Errorf("EstimateCMS = %d, %v", count, err)
+	}
+	if err := app.AddToHLL("x", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := app.EstimateHLL("x"); err != nil || count < 0.5 || count > 1.5 {
+		t.Errorf("EstimateHLL = %f, %v", count, err)
+	}
+	if distance, err := app.SimHashDistance("x", "x"); err != nil || distance != 0 {
+		t.Errorf("SimHashDistance = %d, %v", distance, err)
+	}
+}
+
+func openFiles(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("broj otvorenih fajlova nije dostupan")
+	}
+	return len(fds)
+}
+
+// Kompakcija proverava filtere tabela nizih nivoa za svaki obrisan kljuc, fajlovi i direktorijumi se moraju zatvoriti
+func TestCompactionOpenFiles(t *testing.T) {
+	testDir(t, "memtablemaxbytes: 200\nlsmlevelsize: 2\n")
+	app := openTestApp(t)
+	defer app.Close()
+	app.UnlockTokenBucket()
+
+	before := openFiles(t)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key-%03d", i%40)
+		var err error
+		if i%3 == 0 {
+			err = app.Delete(key)
+		} else {
+			err = app.Put(key, []byte(key))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := openFiles(t); after > before+2 {
+		t.Errorf("posle kompakcija je otvoreno %d fajlova, pre %d", after, before)
+	}
+}
+
+// Upisi vise klijenata istovremeno, pri grupnom commit-u jedan fsync treba da obuhvati upise vise klijenata
+func benchmarkAppPut(b *testing.B, policy string) {
+	testDir(b, "walsyncpolicy: "+policy+"\nwalsegmentsize: 1048576\nmemtablemaxbytes: 1048576\n")
+	app := openTestApp(b)
+	defer app.Close()
+	app.UnlockTokenBucket()
+
+	var n atomic.Int64
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := fmt.Sprint("key-", n.Add(1))
+			if err := app.Put(key, []byte(key)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkAppPutSyncAlways(b *testing.B) {
+	benchmarkAppPut(b, "always")
+}
+
+func BenchmarkAppPutSyncGroup(b *testing.B) {
+	benchmarkAppPut(b, "group")
+}
+
+func BenchmarkAppPutSyncNone(b *testing.B) {
+	benchmarkAppPut(b, "none")
+}
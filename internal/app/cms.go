@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"go-touch-grass/internal/countminsketch"
+)
+
+// Count-min sketch-evi se cuvaju kao obicni podaci pod rezervisanim prefiksom
+const cmsPrefix = "__cms__"
+
+func (app *App) CreateCMS(name string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opPut)
+	if err != nil {
+		return
+	}
+
+	data, err := app.get(cmsPrefix + name)
+	if err != nil {
+		return
+	}
+	if data != nil {
+		return errors.New("count-min sketch sa datim imenom vec postoji")
+	}
+	cms := countminsketch.New(app.config.CmsEpsilon, app.config.CmsDelta)
+	return app.putCMS(name, cms)
+}
+
+func (app *App) AddToCMS(name string, value string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opPut)
+	if err != nil {
+		return
+	}
+
+	cms, err := app.getCMS(name)
+	if err != nil {
+		return
+	}
+	cms.Add(value)
+	return app.putCMS(name, cms)
+}
+
+func (app *App) EstimateCMS(name string, value string) (count uint64, err error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err = app.takeToken(opGet)
+	if err != nil {
+		return
+	}
+
+	cms, err := app.getCMS(name)
+	if err != nil {
+		return
+	}
+	return cms.Estimate(value), nil
+}
+
+func (app *App) DeleteCMS(name string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opDelete)
+	if err != nil {
+		return
+	}
+
+	_, err = app.getCMS(name)
+	if err != nil {
+		return
+	}
+	return app.delete(cmsPrefix + name)
+}
+
+func (app *App) getCMS(name string) (*countminsketch.CountMinSketch, error) {
+	data, err := app.get(cmsPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("count-min sketch sa datim imenom ne postoji")
+	}
+	return countminsketch.Deserialize(bytes.NewReader(data))
+}
+
+func (app *App) putCMS(name string, cms *countminsketch.CountMinSketch) error {
+	buf := new(bytes.Buffer)
+	cms.Serialize(buf)
+	return app.put(cmsPrefix+name, buf.Bytes())
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"go-touch-grass/internal/hyperloglog"
+)
+
+// HyperLogLog-ovi se cuvaju kao obicni podaci pod rezervisanim prefiksom
+const hllPrefix = "__hll__"
+
+func (app *App) CreateHLL(name string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opPut)
+	if err != nil {
+		return
+	}
+
+	data, err := app.get(hllPrefix + name)
+	if err != nil {
+		return
+	}
+	if data != nil {
+		return errors.New("HLL sa datim imenom vec postoji")
+	}
+	hll, err := hyperloglog.New(uint8(app.config.HllPrecision))
+	if err != nil {
+		return
+	}
+	return app.putHLL(name, hll)
+}
+
+func (app *App) AddToHLL(name string, value string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opPut)
+	if err != nil {
+		return
+	}
+
+	hll, err := app.getHLL(name)
+	if err != nil {
+		return
+	}
+	hll.Add(value)
+	return app.putHLL(name, hll)
+}
+
+func (app *App) EstimateHLL(name string) (count float64, err error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err = app.takeToken(opGet)
+	if err != nil {
+		return
+	}
+
+	hll, err := app.getHLL(name)
+	if err != nil {
+		return
+	}
+	return hll.Estimate(), nil
+}
+
+func (app *App) DeleteHLL(name string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opDelete)
+	if err != nil {
+		return
+	}
+
+	_, err = app.getHLL(name)
+	if err != nil {
+		return
+	}
+	return app.delete(hllPrefix + name)
+}
+
+func (app *App) getHLL(name string) (*hyperloglog.HyperLogLog, error) {
+	data, err := app.get(hllPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("HLL sa datim imenom ne postoji")
+	}
+	return hyperloglog.Deserialize(bytes.NewReader(data))
+}
+
+func (app *App) putHLL(name string, hll *hyperloglog.HyperLogLog) error {
+	buf := new(bytes.Buffer)
+	hll.Serialize(buf)
+	return app.put(hllPrefix+name, buf.Bytes())
+}
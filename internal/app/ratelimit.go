@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	conf "go-touch-grass/config"
+	"go-touch-grass/internal/tbucket"
+	"go-touch-grass/internal/util"
+	"sync"
+	"time"
+)
+
+// Operacije koje se naplacuju tokenima, cena se zadaje u config fajlu (OperationCosts)
+const (
+	opGet        = "get"
+	opPut        = "put"
+	opDelete     = "delete"
+	opMerge      = "merge"
+	opScan       = "scan"
+	opCompaction = "compaction"
+)
+
+// Stanje token bucket-a svih klijenata se cuva u bazi pod rezervisanim kljucem podrazumevane familije
+const tbucketKey = internalPrefix + "tbucket"
+
+// Promenjeno stanje se upisuje najkasnije posle ovog intervala, pri padu aplikacije gube se samo tokeni potroseni u njemu
+const tbucketSaveInterval = time.Second
+
+// Token bucket-i klijenata, stanje se ucitava pri pokretanju aplikacije
+type limiter struct {
+	buckets   map[string]*tbucket.TBucket
+	saved     map[string][]byte // sacuvana stanja klijenata koji od pokretanja nisu imali zahteve
+	changed   bool              // stanje nekog bucket-a nije upisano u bazu
+	unlimited bool
+	stop      chan struct{}
+	stopped   sync.Once
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		buckets: make(map[string]*tbucket.TBucket),
+		saved:   make(map[string][]byte),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Velicina stanja koje upisuje tbucket.Serialize
+const tbucketStateSize = 16
+
+// Ucitava stanja token bucket-a iz baze, poziva se posle oporavka iz WAL-a
+func (app *App) loadBuckets() error {
+	data, err := app.get(tbucketKey)
+	if err != nil || data == nil {
+		return err
+	}
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		length, err := util.ReadUint16(r)
+		if err != nil || r.Len() < int(length)+tbucketStateSize {
+			return errors.New("neispravno sacuvano stanje token bucket-a")
+		}
+		client, _ := util.ReadString(int(length), r)
+		state, _ := util.ReadBytes(tbucketStateSize, r)
+		app.limiter.saved[client] = state
+	}
+	return nil
+}
+
+// Upisuje stanja svih klijenata u bazu ako su se promenila od poslednjeg upisa
+// Poziva se sa zakljucanim app.mu
+func (app *App) saveBuckets() error {
+	if !app.limiter.changed {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	for client, state := range app.limiter.saved {
+		if _, ok := app.limiter.buckets[client]; !ok {
+			util.WriteUint(uint16(len(client)), buf)
+			util.WriteString(client, buf)
+			buf.Write(state)
+		}
+	}
+	for client, bucket := range app.limiter.buckets {
+		util.WriteUint(uint16(len(client)), buf)
+		util.WriteString(client, buf)
+		bucket.Serialize(buf)
+	}
+
+	root, _ := app.ColumnFamily(conf.DefaultFamily)
+	if err := root.put(tbucketKey, buf.Bytes()); err != nil {
+		return err
+	}
+	app.limiter.changed = false
+	return nil
+}
+
+// Periodicno upisuje promenjena stanja token bucket-a dok se aplikacija ne zatvori
+// Greska upisa se ne prijavljuje, upis se ponavlja u sledecem intervalu i pri zatvaranju
+func (app *App) saveBucketsPeriodically() {
+	ticker := time.NewTicker(tbucketSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			start := app.lockWrite()
+			err := app.saveBuckets()
+			app.unlockWrite(start, &err)
+		case <-app.limiter.stop:
+			return
+		}
+	}
+}
+
+// Aplikacija cije operacije trose tokene klijenta id, ogranicenje klijenta se zadaje u config fajlu (RateLimits)
+func (app *App) AsClient(id string) *App {
+	client := *app
+	client.client = id
+	return &client
+}
+
+// Aplikacija cije operacije cekaju na tokene dok ctx ne bude prekinut, umesto da vrate tbucket.ErrTooManyRequests
+func (app *App) WithContext(ctx context.Context) *App {
+	blocking := *app
+	blocking.ctx = ctx
+	return &blocking
+}
+
+func (app *App) UnlockTokenBucket() {
+	app.limiter.unlimited = true
+}
+
+func (app *App) CanMakeQuery() error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.takeToken(opGet)
+}
+
+// Uzima tokene za operaciju, novo stanje bucket-a se upisuje u bazu periodicno, a ne pri svakoj operaciji
+// Poziva se sa zakljucanim app.mu, koji se otpusta dok operacija ceka na tokene
+func (app *App) takeToken(op string) error {
+	if app.limiter.unlimited {
+		return nil
+	}
+	bucket, err := app.bucket()
+	if err != nil {
+		return err
+	}
+
+	cost := app.config.OperationCost(op)
+	app.limiter.changed = true
+	if app.ctx == nil {
+		err = bucket.MakeQuery(cost)
+	} else {
+		app.mu.Unlock()
+		err = bucket.Wait(app.ctx, cost)
+		app.mu.Lock()
+	}
+	return err
+}
+
+func (app *App) bucket() (*tbucket.TBucket, error) {
+	if bucket, ok := app.limiter.buckets[app.client]; ok {
+		return bucket, nil
+	}
+	limit := app.config.RateLimit(app.client)
+	bucket := tbucket.NewWithLimit(limit.MaxTokens, limit.ResetDuration)
+
+	// stanje sacuvano pre gasenja aplikacije
+	if state, ok := app.limiter.saved[app.client]; ok {
+		if err := bucket.Restore(bytes.NewReader(state)); err != nil {
+			return nil, err
+		}
+	}
+	app.limiter.buckets[app.client] = bucket
+	return bucket, nil
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"errors"
+	"go-touch-grass/internal/simhash"
+)
+
+// SimHash otisci se cuvaju kao obicni podaci pod rezervisanim prefiksom
+const simhashPrefix = "__simhash__"
+
+func (app *App) PutSimHash(key string, text string) (err error) {
+	defer app.unlockWrite(app.lockWrite(), &err)
+	err = app.takeToken(opPut)
+	if err != nil {
+		return
+	}
+	return app.put(simhashPrefix+key, simhash.Serialize(simhash.Fingerprint(text)))
+}
+
+func (app *App) SimHashDistance(key1 string, key2 string) (distance int, err error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err = app.takeToken(opGet)
+	if err != nil {
+		return
+	}
+
+	a, err := app.getSimHash(key1)
+	if err != nil {
+		return
+	}
+	b, err := app.getSimHash(key2)
+	if err != nil {
+		return
+	}
+	return simhash.HammingDistance(a, b), nil
+}
+
+func (app *App) getSimHash(key string) (uint64, error) {
+	data, err := app.get(simhashPrefix + key)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, errors.New("SimHash otisak sa kljucem [" + key + "] ne postoji")
+	}
+	return simhash.Deserialize(data), nil
+}
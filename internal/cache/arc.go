@@ -0,0 +1,178 @@
+package cache
+
+import "container/list"
+
+/*
+Adaptive Replacement Cache (Megiddo, Modha)
+t1 - kljucevi kojima je pristupljeno jednom, t2 - kljucevi kojima je pristupljeno vise puta
+b1, b2 - kljucevi nedavno izbaceni iz t1 i t2, bez vrednosti
+p - ciljna velicina t1, raste kada se trazi kljuc iz b1 a smanjuje kada se trazi kljuc iz b2,
+
+	pa jedno veliko skeniranje ne izbacuje kljuceve iz t2
+*/
+type arc struct {
+	size           int
+	p              int
+	t1, t2, b1, b2 *list.List
+	items          map[string]*arcEntry
+}
+
+type arcEntry struct {
+	key     string
+	node    *Node // nil za kljuceve iz b1 i b2
+	list    *list.List
+	element *list.Element
+}
+
+func newARC(size int) *arc {
+	c := &arc{size: size}
+	c.Clear()
+	return c
+}
+
+func (c *arc) Get(key string) (*Node, bool) {
+	entry, exists := c.items[key]
+	if !exists || entry.node == nil {
+		return nil, false
+	}
+	c.move(entry, c.t2)
+	return entry.node, true
+}
+
+func (c *arc) Peek(key string) (*Node, bool) {
+	if entry, exists := c.items[key]; exists && entry.node != nil {
+		return entry.node, true
+	}
+	return nil, false
+}
+
+func (c *arc) Add(node *Node) []*Node {
+	if c.size <= 0 {
+		return []*Node{node}
+	}
+	var evicted *Node
+	entry, exists := c.items[node.key]
+	switch {
+	case exists && entry.list == c.b1:
+		c.p = min(c.size, c.p+max(c.b2.Len()/c.b1.Len(), 1))
+		evicted = c.replace(false)
+		entry.node = node
+		c.move(entry, c.t2)
+	case exists && entry.list == c.b2:
+		c.p = max(0, c.p-max(c.b1.Len()/c.b2.Len(), 1))
+		evicted = c.replace(true)
+		entry.node = node
+		c.move(entry, c.t2)
+	default:
+		if c.t1.Len()+c.b1.Len() >= c.size {
+			if c.t1.Len() < c.size {
+				c.drop(c.b1)
+				evicted = c.replace(false)
+			} else {
+				evicted = c.drop(c.t1)
+			}
+		} else if c.t1.Len()+c.t2.Len()+c.b1.Len()+c.b2.Len() >= c.size {
+			if c.t1.Len()+c.t2.Len()+c.b1.Len()+c.b2.Len() >= 2*c.size {
+				c.drop(c.b2)
+			}
+			evicted = c.replace(false)
+		}
+		entry = &arcEntry{key: node.key, node: node}
+		c.items[node.key] = entry
+		c.move(entry, c.t1)
+	}
+	if evicted == nil {
+		return nil
+	}
+	return []*Node{evicted}
+}
+
+func (c *arc) Evict() (*Node, bool) {
+	if c.t1.Len()+c.t2.Len() == 0 {
+		return nil, false
+	}
+	node := c.demote(false)
+	c.trimGhosts()
+	return node, true
+}
+
+// Izbacivanje zbog velicine u bajtovima ne prolazi kroz Add, pa se granice lista izbacenih kljuceva proveravaju posle svakog
+// Najstariji izbaceni kljucevi se brisu dok ne vazi t1+b1 <= size i t1+t2+b1+b2 <= 2*size
+func (c *arc) trimGhosts() {
+	for c.b1.Len() > 0 && c.t1.Len()+c.b1.Len() > c.size {
+		c.drop(c.b1)
+	}
+	for c.t1.Len()+c.t2.Len()+c.b1.Len()+c.b2.Len() > 2*c.size {
+		if c.b2.Len() > 0 {
+			c.drop(c.b2)
+		} else {
+			c.drop(c.b1)
+		}
+	}
+}
+
+// Izbacuje vrednost iz t1 ili t2 u odgovarajucu listu izbacenih kljuceva ako je kes pun
+func (c *arc) replace(inB2 bool) *Node {
+	if c.t1.Len()+c.t2.Len() < c.size {
+		return nil
+	}
+	return c.demote(inB2)
+}
+
+func (c *arc) demote(inB2 bool) *Node {
+	from, to := c.t2, c.b2
+	if c.t2.Len() == 0 || (c.t1.Len() > 0 && (c.t1.Len() > c.p || (inB2 && c.t1.Len() == c.p))) {
+		from, to = c.t1, c.b1
+	}
+	entry := from.Back().Value.(*arcEntry)
+	node := entry.node
+	entry.node = nil
+	c.move(entry, to)
+	return node
+}
+
+// Potpuno uklanja najstariji kljuc iz liste, vraca njegovu vrednost ako nije bio medju izbacenim
+func (c *arc) drop(l *list.List) *Node {
+	element := l.Back()
+	if element == nil {
+		return nil
+	}
+	entry := element.Value.(*arcEntry)
+	l.Remove(element)
+	delete(c.items, entry.key)
+	return entry.node
+}
+
+func (c *arc) move(entry *arcEntry, to *list.List) {
+	if entry.list != nil {
+		entry.list.Remove(entry.element)
+	}
+	entry.list = to
+	entry.element = to.PushFront(entry)
+}
+
+func (c *arc) Remove(key string) bool {
+	entry, exists := c.items[key]
+	if !exists {
+		return false
+	}
+	entry.list.Remove(entry.element)
+	delete(c.items, key)
+	return entry.node != nil
+}
+
+func (c *arc) Keys() []string {
+	keys := make([]string, 0, c.t1.Len()+c.t2.Len())
+	for _, l := range []*list.List{c.t2, c.t1} {
+		for element := l.Front(); element != nil; element = element.Next() {
+			keys = append(keys, element.Value.(*arcEntry).key)
+		}
+	}
+	return keys
+}
+
+func (c *arc) Clear() {
+	c.p = 0
+	c.t1, c.t2, c.b1, c.b2 = list.New(), list.New(), list.New(), list.New()
+	c.items = make(map[string]*arcEntry)
+}
@@ -1,75 +1,204 @@
 package cache
 
 import (
-	"container/list"
+	"errors"
 	"fmt"
+	"time"
 )
 
+// Politika izbacivanja, cuva najvise onoliko kljuceva koliki je kapacitet kesa
+type Policy interface {
+	Get(key string) (*Node, bool)  // pristup kljucu, menja redosled izbacivanja
+	Peek(key string) (*Node, bool) // redosled izbacivanja se ne menja
+	Add(node *Node) []*Node        // dodavanje kljuca koji nije u kesu, vraca izbacene kljuceve
+	Evict() (*Node, bool)          // izbacuje kljuc koji bi politika sledeci izbacila
+	Remove(key string) bool
+	Keys() []string
+	Clear()
+}
+
+// Procena memorije cvora i strukture politike po kljucu, dodaje se na duzinu kljuca i vrednosti
+const nodeOverhead = 64
+
 type Cache struct {
-	size     int
-	list     *list.List
-	data_map map[string]*list.Element
+	policy   Policy
+	stats    Stats
+	maxBytes int64
+	bytes    int64
 }
 
 type Node struct {
-	key   string
-	value []byte
+	key     string
+	value   []byte
+	expires time.Time // nulta vrednost znaci da vrednost ne istice
+	missing bool      // zapamceno je da kljuc ne postoji u bazi, value je nil
 }
 
-func New(size int) *Cache {
-	return &Cache{
-		size:     size,
-		list:     list.New(),
-		data_map: make(map[string]*list.Element),
+// Broj citanja koja su pronasla kljuc u kesu i onih koja nisu
+// NegativeHits su pogoci u kojima je kes vratio da kljuc ne postoji, ukljuceni su u Hits
+type Stats struct {
+	Hits         uint64
+	Misses       uint64
+	NegativeHits uint64
+}
+
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
 	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
 }
 
-func (c *Cache) Add(key string, value []byte) {
-	if element, exists := c.data_map[key]; exists {
-		c.list.MoveToFront(element) //ako element postoji, stavi ga na pocetak
-		element.Value.(*Node).value = value
-	} else {
-		newElement := &Node{key: key, value: value}
-		if c.list.Len() >= c.size {
+func (n *Node) expired(now time.Time) bool {
+	return !n.expires.IsZero() && !now.Before(n.expires)
+}
 
-			lastElement := c.list.Back()
-			if lastElement != nil { //ako je lista puna, obrisi poslednji item
-				delete(c.data_map, lastElement.Value.(*Node).key)
-				c.list.Remove(lastElement)
-			}
-		}
-		elem := c.list.PushFront(newElement) //dodaj item na pocetak
-		c.data_map[key] = elem
+func (n *Node) size() int64 {
+	return int64(len(n.key)+len(n.value)) + nodeOverhead
+}
+
+// LRU kes, maxBytes mora biti veci od 0
+func New(size int, maxBytes int64) (*Cache, error) {
+	return NewWithPolicy("lru", size, maxBytes)
+}
+
+// Kes sa politikom izbacivanja iz config fajla (CachePolicy)
+// Ukupna velicina kljuceva i vrednosti je ogranicena sa maxBytes, a broj kljuceva sa size ako je veci od 0
+func NewWithPolicy(policy string, size int, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("velicina kesa mora biti veca od 0")
 	}
+	if size <= 0 {
+		// svaki kljuc zauzima bar nodeOverhead bajtova, pa ovaj broj nikada ne ogranicava pre velicine
+		size = int(max(maxBytes/nodeOverhead, 1))
+	}
+	c := &Cache{maxBytes: maxBytes}
+	switch policy {
+	case "lru":
+		c.policy = newLRU(size)
+	case "lfu":
+		c.policy = newLFU(size)
+	case "arc":
+		c.policy = newARC(size)
+	case "w-tinylfu":
+		c.policy = newTinyLFU(size)
+	default:
+		return nil, errors.New("nepoznata politika kesa " + policy)
+	}
+	return c, nil
+}
+
+func (c *Cache) Add(key string, value []byte, expires time.Time) {
+	c.add(&Node{key: key, value: value, expires: expires})
+}
+
+// Pamti da kljuc ne postoji u bazi, da sledece citanje ne bi pretrazivalo SSTabele
+// Upis vrednosti kljuca preko Update ili Add zamenjuje ovaj zapis
+func (c *Cache) AddMissing(key string) {
+	c.add(&Node{key: key, missing: true})
 }
 
-func (c *Cache) Get(key string) []byte {
-	if element, exists := c.data_map[key]; exists { //bool je da li element postoji, drugo je sam element
-		c.list.MoveToFront(element)
-		return element.Value.(*Node).value
+func (c *Cache) add(node *Node) {
+	if existing, exists := c.policy.Get(node.key); exists {
+		c.set(existing, node.value, node.expires)
+		existing.missing = node.missing
+		return
 	}
-	return nil
+	if node.size() > c.maxBytes {
+		// vrednost veca od celog kesa bi izbacila sve ostale kljuceve
+		return
+	}
+	c.bytes += node.size()
+	for _, evicted := range c.policy.Add(node) {
+		c.bytes -= evicted.size()
+	}
+	c.shrink()
 }
 
-func (c *Cache) Remove(key string) bool { //vraca true ako je bio u listi, false ako nije
-	if element, exists := c.data_map[key]; exists {
-		delete(c.data_map, key)
-		c.list.Remove(element)
+// Menja vrednost kljuca koji je vec u kesu, redosled izbacivanja se ne menja jer ga odredjuju citanja
+// Vraca false ako kljuc nije u kesu
+func (c *Cache) Update(key string, value []byte, expires time.Time) bool {
+	if node, exists := c.policy.Peek(key); exists {
+		c.set(node, value, expires)
+		node.missing = false
 		return true
 	}
 	return false
 }
 
-func (c *Cache) PrintCache() {
+func (c *Cache) set(node *Node, value []byte, expires time.Time) {
+	c.bytes -= node.size()
+	node.value = value
+	node.expires = expires
+	c.bytes += node.size()
+	c.shrink()
+}
+
+// Izbacuje kljuceve dok velicina kesa ne bude u granicama maxBytes
+func (c *Cache) shrink() {
+	for c.bytes > c.maxBytes {
+		node, ok := c.policy.Evict()
+		if !ok {
+			return
+		}
+		c.bytes -= node.size()
+	}
+}
 
+// Vraca vrednost kljuca, ili missing ako je u kesu zapamceno da kljuc ne postoji
+// Ako kljuc nije u kesu vraca nil i false
+func (c *Cache) Get(key string) (value []byte, missing bool) {
+	node, exists := c.policy.Get(key)
+	if exists && node.expired(time.Now()) {
+		// istekla vrednost se izbacuje iz kesa
+		c.Remove(key)
+		exists = false
+	}
+	if !exists {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	if node.missing {
+		c.stats.NegativeHits++
+	}
+	return node.value, node.missing
+}
+
+// Vrednost kljuca bez menjanja redosleda izbacivanja, nil i za kljuc za koji se zna da ne postoji
+func (c *Cache) Peek(key string) []byte {
+	if node, exists := c.policy.Peek(key); exists && !node.expired(time.Now()) {
+		return node.value
+	}
+	return nil
+}
+
+func (c *Cache) Remove(key string) bool { //vraca true ako je bio u kesu, false ako nije
+	node, exists := c.policy.Peek(key)
+	if !exists {
+		return false
+	}
+	c.bytes -= node.size()
+	return c.policy.Remove(key)
+}
+
+func (c *Cache) Stats() Stats {
+	return c.stats
+}
+
+func (c *Cache) PrintCache() {
 	fmt.Println("Cache:")
-	for element := c.list.Front(); element != nil; element = element.Next() {
-		node := element.Value.(*Node)
+	for _, key := range c.policy.Keys() {
+		node, _ := c.policy.Peek(key)
+		if node.missing {
+			fmt.Printf("Key: %s, ne postoji\n", node.key)
+			continue
+		}
 		fmt.Printf("Key: %s, Value: %s\n", node.key, string(node.value))
 	}
 }
 
 func (c *Cache) Clear() {
-	c.list = list.New()
-	c.data_map = make(map[string]*list.Element)
+	c.policy.Clear()
+	c.bytes = 0
 }
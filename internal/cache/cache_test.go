@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var policies = []string{"lru", "lfu", "arc", "w-tinylfu"}
+
+func newTestCache(t *testing.T, policy string, size int) *Cache {
+	c, err := NewWithPolicy(policy, size, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestInvalid(t *testing.T) {
+	if c, err := New(10, 0); err == nil || c != nil {
+		t.Errorf("kes velicine 0 je napravljen")
+	}
+	if c, err := NewWithPolicy("fifo", 10, 1024); err == nil || c != nil {
+		t.Errorf("kes sa nepoznatom politikom je napravljen")
+	}
+	if _, err := New(10, 1024); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for _, policy := range policies {
+		c := newTestCache(t, policy, 10)
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint(i)
+			if v, _ := c.Get(key); v == nil {
+				c.Add(key, []byte(key), time.Time{})
+			}
+			if n := len(c.policy.Keys()); n > 10 {
+				t.Fatalf("%s: %d kljuceva u kesu kapaciteta 10", policy, n)
+			}
+		}
+		c.Add("x", []byte("1"), time.Time{})
+		c.Update("x", []byte("2"), time.Time{})
+		if v, _ := c.Get("x"); string(v) != "2" {
+			t.Errorf("%s: Get(x) = %q, ocekivano 2", policy, v)
+		}
+		c.Remove("x")
+		if v, _ := c.Get("x"); v != nil {
+			t.Errorf("%s: obrisan kljuc je i dalje u kesu", policy)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	for _, policy := range policies {
+		c := newTestCache(t, policy, 4)
+		c.Add("a", []byte("a"), time.Now().Add(-time.Second))
+		if v, _ := c.Get("a"); v != nil || c.Peek("a") != nil {
+			t.Errorf("%s: istekla vrednost je vracena iz kesa", policy)
+		}
+	}
+}
+
+// Cesto trazeni kljucevi treba da ostanu u kesu posle skeniranja mnogo kljuceva koji se traze jednom
+func TestScanResistance(t *testing.T) {
+	for _, policy := range []string{"lfu", "arc", "w-tinylfu"} {
+		c := newTestCache(t, policy, 100)
+		read := func(key string) {
+			if v, _ := c.Get(key); v == nil {
+				c.Add(key, []byte(key), time.Time{})
+			}
+		}
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 50; i++ {
+				read(fmt.Sprint("hot-", i))
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			read(fmt.Sprint("scan-", i))
+		}
+
+		before := c.Stats()
+		for i := 0; i < 50; i++ {
+			read(fmt.Sprint("hot-", i))
+		}
+		hits := c.Stats().Hits - before.Hits
+		if hits < 40 {
+			t.Errorf("%s: posle skeniranja pronadjeno %d od 50 cestih kljuceva", policy, hits)
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	for _, policy := range policies {
+		c, err := NewWithPolicy(policy, 0, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint(i)
+			if v, _ := c.Get(key); v == nil {
+				c.Add(key, make([]byte, 10*(i%20)), time.Time{})
+			}
+			if c.bytes > 1000 {
+				t.Fatalf("%s: %d bajtova u kesu velicine 1000", policy, c.bytes)
+			}
+		}
+		c.Add("big", make([]byte, 2000), time.Time{})
+		if c.Peek("big") != nil {
+			t.Errorf("%s: vrednost veca od kesa je dodata", policy)
+		}
+
+		var total int64
+		for _, key := range c.policy.Keys() {
+			node, _ := c.policy.Peek(key)
+			total += node.size()
+		}
+		if total != c.bytes {
+			t.Errorf("%s: velicina kesa %d, a zbir velicina kljuceva %d", policy, c.bytes, total)
+		}
+	}
+}
+
+// Vrednosti razlicitih velicina izbacuju vise kljuceva odjednom, ali liste izbacenih kljuceva ne smeju preci 2*size
+func TestARCGhosts(t *testing.T) {
+	c, err := NewWithPolicy("arc", 0, 20*nodeOverhead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := c.policy.(*arc)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprint(r.Intn(60))
+		switch r.Intn(4) {
+		case 0:
+			c.Get(key)
+		case 1:
+			c.Remove(key)
+		default:
+			c.Add(key, make([]byte, r.Intn(18*nodeOverhead)), time.Time{})
+		}
+		total := a.t1.Len() + a.t2.Len() + a.b1.Len() + a.b2.Len()
+		if total > 2*a.size || a.t1.Len()+a.b1.Len() > a.size || total != len(a.items) {
+			t.Fatalf("t1=%d t2=%d b1=%d b2=%d, size %d", a.t1.Len(), a.t2.Len(), a.b1.Len(), a.b2.Len(), a.size)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	for _, policy := range policies {
+		c := newTestCache(t, policy, 4)
+		c.AddMissing("a")
+		if v, missing := c.Get("a"); v != nil || !missing {
+			t.Errorf("%s: Get(a) = %q, %v, ocekivano da kljuc ne postoji", policy, v, missing)
+		}
+		if c.Peek("a") != nil {
+			t.Errorf("%s: Peek vraca vrednost kljuca koji ne postoji", policy)
+		}
+		c.Update("a", []byte("1"), time.Time{})
+		if v, missing := c.Get("a"); string(v) != "1" || missing {
+			t.Errorf("%s: Get(a) = %q, %v posle upisa, ocekivano 1", policy, v, missing)
+		}
+	}
+	c := newTestCache(t, "lru", 4)
+	c.AddMissing("a")
+	c.Get("a")
+	if s := c.Stats(); s.Hits != 1 || s.NegativeHits != 1 {
+		t.Errorf("Stats() = %+v, ocekivan 1 negativan pogodak", s)
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := newTestCache(t, "lru", 2)
+	c.Get("a")
+	c.Add("a", []byte("a"), time.Time{})
+	c.Get("a")
+	c.Get("a")
+	if s := c.Stats(); s.Hits != 2 || s.Misses != 1 {
+		t.Errorf("Stats() = %+v, ocekivano 2 pogotka i 1 promasaj", s)
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import "container/list"
+
+// Izbacuje kljuc sa najmanje pristupa, medju njima onaj kome se najduze nije pristupalo
+// Kljucevi sa istim brojem pristupa su u istoj listi, pa su sve operacije O(1)
+type lfu struct {
+	size    int
+	items   map[string]*lfuEntry
+	freqs   map[int]*list.List
+	minFreq int
+}
+
+type lfuEntry struct {
+	node    *Node
+	freq    int
+	element *list.Element
+}
+
+func newLFU(size int) *lfu {
+	return &lfu{
+		size:  size,
+		items: make(map[string]*lfuEntry),
+		freqs: make(map[int]*list.List),
+	}
+}
+
+func (c *lfu) Get(key string) (*Node, bool) {
+	entry, exists := c.items[key]
+	if !exists {
+		return nil, false
+	}
+	c.unlink(entry)
+	entry.freq++
+	c.link(entry)
+	return entry.node, true
+}
+
+func (c *lfu) Peek(key string) (*Node, bool) {
+	if entry, exists := c.items[key]; exists {
+		return entry.node, true
+	}
+	return nil, false
+}
+
+func (c *lfu) Add(node *Node) []*Node {
+	if c.size <= 0 {
+		return []*Node{node}
+	}
+	var evicted []*Node
+	if len(c.items) >= c.size {
+		if victim, ok := c.Evict(); ok {
+			evicted = append(evicted, victim)
+		}
+	}
+	entry := &lfuEntry{node: node, freq: 1}
+	c.items[node.key] = entry
+	c.link(entry)
+	c.minFreq = 1
+	return evicted
+}
+
+func (c *lfu) Evict() (*Node, bool) {
+	if _, ok := c.freqs[c.minFreq]; !ok {
+		// najmanja frekvencija nije poznata posle uklanjanja kljuca
+		c.minFreq = 0
+		for freq := range c.freqs {
+			if c.minFreq == 0 || freq < c.minFreq {
+				c.minFreq = freq
+			}
+		}
+	}
+	l, ok := c.freqs[c.minFreq]
+	if !ok {
+		return nil, false
+	}
+	node := l.Back().Value.(*lfuEntry).node
+	c.Remove(node.key)
+	return node, true
+}
+
+func (c *lfu) link(entry *lfuEntry) {
+	l, ok := c.freqs[entry.freq]
+	if !ok {
+		l = list.New()
+		c.freqs[entry.freq] = l
+	}
+	entry.element = l.PushFront(entry)
+}
+
+func (c *lfu) unlink(entry *lfuEntry) {
+	l := c.freqs[entry.freq]
+	l.Remove(entry.element)
+	if l.Len() == 0 {
+		delete(c.freqs, entry.freq)
+		if c.minFreq == entry.freq {
+			c.minFreq++
+		}
+	}
+}
+
+func (c *lfu) Remove(key string) bool {
+	entry, exists := c.items[key]
+	if !exists {
+		return false
+	}
+	c.unlink(entry)
+	delete(c.items, key)
+	return true
+}
+
+func (c *lfu) Keys() []string {
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *lfu) Clear() {
+	c.items = make(map[string]*lfuEntry)
+	c.freqs = make(map[int]*list.List)
+	c.minFreq = 0
+}
@@ -0,0 +1,79 @@
+package cache
+
+import "container/list"
+
+// Izbacuje kljuc kome se najduze nije pristupalo
+type lru struct {
+	size     int
+	list     *list.List
+	data_map map[string]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:     size,
+		list:     list.New(),
+		data_map: make(map[string]*list.Element),
+	}
+}
+
+func (c *lru) Get(key string) (*Node, bool) {
+	if element, exists := c.data_map[key]; exists {
+		c.list.MoveToFront(element)
+		return element.Value.(*Node), true
+	}
+	return nil, false
+}
+
+func (c *lru) Peek(key string) (*Node, bool) {
+	if element, exists := c.data_map[key]; exists {
+		return element.Value.(*Node), true
+	}
+	return nil, false
+}
+
+func (c *lru) Add(node *Node) []*Node {
+	if c.size <= 0 {
+		return []*Node{node}
+	}
+	var evicted []*Node
+	if c.list.Len() >= c.size {
+		// ako je lista puna, obrisi poslednji item
+		oldest, _ := c.Evict()
+		evicted = append(evicted, oldest)
+	}
+	c.data_map[node.key] = c.list.PushFront(node)
+	return evicted
+}
+
+func (c *lru) Evict() (*Node, bool) {
+	element := c.list.Back()
+	if element == nil {
+		return nil, false
+	}
+	node := element.Value.(*Node)
+	c.Remove(node.key)
+	return node, true
+}
+
+func (c *lru) Remove(key string) bool {
+	if element, exists := c.data_map[key]; exists {
+		delete(c.data_map, key)
+		c.list.Remove(element)
+		return true
+	}
+	return false
+}
+
+func (c *lru) Keys() []string {
+	keys := make([]string, 0, c.list.Len())
+	for element := c.list.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*Node).key)
+	}
+	return keys
+}
+
+func (c *lru) Clear() {
+	c.list = list.New()
+	c.data_map = make(map[string]*list.Element)
+}
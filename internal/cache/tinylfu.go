@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+/*
+W-TinyLFU (Einziger, Friedman, Manes)
+Novi kljucevi ulaze u mali LRU prozor (1% kapaciteta), a glavni deo je segmentirani LRU:
+probation - kljucevi kojima posle ulaska nije ponovo pristupljeno, protected - 80% glavnog dela
+Kljuc izbacen iz prozora ulazi u glavni deo samo ako mu je procenjen broj pristupa veci od kljuca
+koji bi iz glavnog dela bio izbacen, pa kljucevi iz skeniranja ne potiskuju cesto trazene
+*/
+const (
+	windowSegment = iota
+	probationSegment
+	protectedSegment
+)
+
+type tinyLFU struct {
+	caps     [3]int
+	segments [3]*list.List
+	items    map[string]*tinyLFUEntry
+	sketch   *frequencySketch
+}
+
+type tinyLFUEntry struct {
+	node    *Node
+	segment int
+	element *list.Element
+}
+
+func newTinyLFU(size int) *tinyLFU {
+	window := max(1, size/100)
+	main := max(0, size-window)
+	protected := main * 8 / 10
+	c := &tinyLFU{
+		caps:   [3]int{window, main - protected, protected},
+		sketch: newFrequencySketch(size),
+	}
+	c.Clear()
+	return c
+}
+
+func (c *tinyLFU) Get(key string) (*Node, bool) {
+	// broje se svi pristupi, i oni koji ne pronadju kljuc
+	c.sketch.increment(key)
+	entry, exists := c.items[key]
+	if !exists {
+		return nil, false
+	}
+	switch entry.segment {
+	case probationSegment:
+		c.move(entry, protectedSegment)
+		if c.segments[protectedSegment].Len() > c.caps[protectedSegment] {
+			// najstariji zasticeni kljuc se vraca na probni deo
+			c.move(c.segments[protectedSegment].Back().Value.(*tinyLFUEntry), probationSegment)
+		}
+	default:
+		c.segments[entry.segment].MoveToFront(entry.element)
+	}
+	return entry.node, true
+}
+
+func (c *tinyLFU) Peek(key string) (*Node, bool) {
+	if entry, exists := c.items[key]; exists {
+		return entry.node, true
+	}
+	return nil, false
+}
+
+func (c *tinyLFU) Add(node *Node) []*Node {
+	entry := &tinyLFUEntry{node: node, segment: -1}
+	c.items[node.key] = entry
+	c.move(entry, windowSegment)
+	if c.segments[windowSegment].Len() <= c.caps[windowSegment] {
+		return nil
+	}
+
+	candidate := c.segments[windowSegment].Back().Value.(*tinyLFUEntry)
+	if c.segments[probationSegment].Len()+c.segments[protectedSegment].Len() < c.caps[probationSegment]+c.caps[protectedSegment] {
+		c.move(candidate, probationSegment)
+		return nil
+	}
+	victim := c.segments[probationSegment].Back()
+	if victim == nil {
+		victim = c.segments[protectedSegment].Back()
+	}
+	if victim == nil || c.sketch.estimate(candidate.node.key) <= c.sketch.estimate(victim.Value.(*tinyLFUEntry).node.key) {
+		c.Remove(candidate.node.key)
+		return []*Node{candidate.node}
+	}
+	evicted := victim.Value.(*tinyLFUEntry).node
+	c.Remove(evicted.key)
+	c.move(candidate, probationSegment)
+	return []*Node{evicted}
+}
+
+// Prvo se izbacuju kljucevi sa probnog dela, pa iz prozora, a zasticeni tek na kraju
+func (c *tinyLFU) Evict() (*Node, bool) {
+	for _, segment := range []int{probationSegment, windowSegment, protectedSegment} {
+		if element := c.segments[segment].Back(); element != nil {
+			node := element.Value.(*tinyLFUEntry).node
+			c.Remove(node.key)
+			return node, true
+		}
+	}
+	return nil, false
+}
+
+func (c *tinyLFU) move(entry *tinyLFUEntry, segment int) {
+	if entry.segment >= 0 {
+		c.segments[entry.segment].Remove(entry.element)
+	}
+	entry.segment = segment
+	entry.element = c.segments[segment].PushFront(entry)
+}
+
+func (c *tinyLFU) Remove(key string) bool {
+	entry, exists := c.items[key]
+	if !exists {
+		return false
+	}
+	c.segments[entry.segment].Remove(entry.element)
+	delete(c.items, key)
+	return true
+}
+
+func (c *tinyLFU) Keys() []string {
+	keys := make([]string, 0, len(c.items))
+	for _, segment := range []int{protectedSegment, probationSegment, windowSegment} {
+		for element := c.segments[segment].Front(); element != nil; element = element.Next() {
+			keys = append(keys, element.Value.(*tinyLFUEntry).node.key)
+		}
+	}
+	return keys
+}
+
+func (c *tinyLFU) Clear() {
+	for i := range c.segments {
+		c.segments[i] = list.New()
+	}
+	c.items = make(map[string]*tinyLFUEntry)
+}
+
+// Count-min sketch sa 4-bitnim brojacima, posle 10*size pristupa svi brojaci se polove
+// da bi procena pratila skorasnju ucestalost
+type frequencySketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(size int) *frequencySketch {
+	width := 16
+	for width < 4*size {
+		width *= 2
+	}
+	s := &frequencySketch{mask: uint32(width - 1), resetAt: max(10*size, 16)}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *frequencySketch) indexes(key string) [4]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	var indexes [4]uint32
+	for i := range indexes {
+		indexes[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+	return indexes
+}
+
+func (s *frequencySketch) increment(key string) {
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < 15 {
+			s.rows[i][index]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		for _, row := range s.rows {
+			for j := range row {
+				row[j] /= 2
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *frequencySketch) estimate(key string) uint8 {
+	estimate := uint8(15)
+	for i, index := range s.indexes(key) {
+		estimate = min(estimate, s.rows[i][index])
+	}
+	return estimate
+}
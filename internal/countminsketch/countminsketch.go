@@ -0,0 +1,104 @@
+package countminsketch
+
+import (
+	"errors"
+	"go-touch-grass/internal/hash"
+	"go-touch-grass/internal/util"
+	"io"
+	"math"
+)
+
+// ln(1/delta) za najmanju vrednost delta koja se moze zapisati u float64
+const maxHashes = 745
+
+type CountMinSketch struct {
+	m, k   uint32 // k - broj hash f-ija (redova), m - broj kolona
+	table  [][]uint64
+	hashes []hash.SeededHash
+}
+
+// kreiranje novog count-min sketch-a
+// epsilon - dozvoljena greska procene, delta - verovatnoca da greska bude veca
+func New(epsilon float64, delta float64) *CountMinSketch {
+	init := &CountMinSketch{}
+	init.m = uint32(math.Ceil(math.E / epsilon))
+	init.k = uint32(math.Ceil(math.Log(1 / delta)))
+	init.table = make([][]uint64, init.k)
+	for i := range init.table {
+		init.table[i] = make([]uint64, init.m)
+	}
+	init.hashes = hash.NewHashes(uint(init.k))
+	return init
+}
+
+// dodavanje jednog pojavljivanja podatka
+func (cms *CountMinSketch) Add(key string) {
+	b := []byte(key)
+	for i, h := range cms.hashes {
+		j := uint32(h.Hash(b)) % cms.m
+		cms.table[i][j]++
+	}
+}
+
+// procena broja pojavljivanja podatka (nikad manja od stvarne)
+func (cms *CountMinSketch) Estimate(key string) uint64 {
+	b := []byte(key)
+	min := uint64(math.MaxUint64)
+	for i, h := range cms.hashes {
+		j := uint32(h.Hash(b)) % cms.m
+		if cms.table[i][j] < min {
+			min = cms.table[i][j]
+		}
+	}
+	return min
+}
+
+func (cms *CountMinSketch) Serialize(w io.Writer) int {
+	util.WriteUint(cms.m, w)
+	util.WriteUint(cms.k, w)
+	for _, row := range cms.table {
+		for _, v := range row {
+			util.WriteUint(v, w)
+		}
+	}
+	for _, h := range cms.hashes {
+		util.WriteBytes(h.Seed, w)
+	}
+	return 8 + 8*int(cms.m)*int(cms.k) + 32*int(cms.k)
+}
+
+// Dimenzije se proveravaju pre citanja tabele, a tabela se cita red po red
+// kako ostecen zapis ne bi zauzeo memoriju za m*k brojaca koji ne postoje
+func Deserialize(r io.Reader) (*CountMinSketch, error) {
+	m, err := util.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	k, err := util.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if m == 0 || k == 0 || k > maxHashes {
+		return nil, errors.New("neispravne dimenzije count-min sketch-a")
+	}
+	table := make([][]uint64, k)
+	hashes := make([]hash.SeededHash, k)
+	for i := uint32(0); i < k; i++ {
+		table[i] = make([]uint64, 0, min(m, 1024))
+		for j := uint32(0); j < m; j++ {
+			v, err := util.ReadUint64(r)
+			if err != nil {
+				return nil, errors.New("count-min sketch je nepotpun")
+			}
+			table[i] = append(table[i], v)
+		}
+	}
+	for i := uint32(0); i < k; i++ {
+		seed, err := util.ReadBytes(32, r)
+		if err != nil {
+			return nil, errors.New("count-min sketch je nepotpun")
+		}
+		hashes[i] = hash.SeededHash{Seed: seed}
+	}
+	return &CountMinSketch{m, k, table, hashes}, nil
+}
@@ -0,0 +1,89 @@
+package countminsketch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	cms := New(0.01, 0.01)
+	counts := make(map[string]uint64)
+	var total uint64
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i % 100)
+		for j := 0; j <= i%7; j++ {
+			cms.Add(key)
+			counts[key]++
+			total++
+		}
+	}
+	// procena nije manja od stvarnog broja, a greska je najvise epsilon*total uz verovatnocu 1-delta
+	// seed-ovi su nasumicni, pa se dozvoljava nekoliko vecih gresaka
+	exceeded := 0
+	for key, count := range counts {
+		estimate := cms.Estimate(key)
+		if estimate < count {
+			t.Errorf("Estimate(%s) = %d, manje od stvarnog %d", key, estimate, count)
+		}
+		if float64(estimate-count) > 0.01*float64(total) {
+			exceeded++
+		}
+	}
+	if exceeded > 5 {
+		t.Errorf("greska veca od epsilon*total za %d od %d kljuceva", exceeded, len(counts))
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	cms := New(0.1, 0.1)
+	for i := 0; i < 50; i++ {
+		cms.Add(fmt.Sprint(i % 5))
+	}
+	buf := new(bytes.Buffer)
+	if n := cms.Serialize(buf); n != buf.Len() {
+		t.Errorf("Serialize vraca %d, upisano %d bajtova", n, buf.Len())
+	}
+	loaded, err := Deserialize(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint(i)
+		if loaded.Estimate(key) != cms.Estimate(key) {
+			t.Errorf("Estimate(%s) = %d posle deserijalizacije, ocekivano %d", key, loaded.Estimate(key), cms.Estimate(key))
+		}
+	}
+}
+
+func TestDeserializeInvalid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	New(0.1, 0.1).Serialize(buf)
+	data := buf.Bytes()
+
+	dimensions := func(m uint32, k uint32) []byte {
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header, m)
+		binary.BigEndian.PutUint32(header[4:], k)
+		return header
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"prazan", nil},
+		{"bez k", data[:4]},
+		{"m je 0", dimensions(0, 3)},
+		{"k je 0", dimensions(28, 0)},
+		{"prevelik k", dimensions(28, 1<<20)},
+		{"prevelik m", dimensions(1<<31, 3)},
+		{"bez tabele", data[:8]},
+		{"bez seed-ova", data[:len(data)-3*32]},
+	}
+	for _, test := range tests {
+		if _, err := Deserialize(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: Deserialize nije vratio gresku", test.name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package hyperloglog
+
+import (
+	"errors"
+	"go-touch-grass/internal/hash"
+	"go-touch-grass/internal/util"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	MinPrecision = 4
+	MaxPrecision = 16
+)
+
+// svi HLL-ovi koriste istu hash f-iju kako bi mogli da se spajaju
+var hll_hash = hash.SeededHash{Seed: []byte("hyperloglog")}
+
+type HyperLogLog struct {
+	p         uint8 // preciznost, broj bitova za izbor registra
+	m         uint32
+	registers []uint8
+}
+
+// kreiranje novog HLL sa 2^p registara
+func New(p uint8) (*HyperLogLog, error) {
+	if p < MinPrecision || p > MaxPrecision {
+		return nil, errors.New("preciznost HLL mora biti izmedju 4 i 16")
+	}
+	m := uint32(1) << p
+	return &HyperLogLog{p: p, m: m, registers: make([]uint8, m)}, nil
+}
+
+func (hll *HyperLogLog) Add(key string) {
+	x := hll_hash.Hash([]byte(key))
+	i := x >> (64 - hll.p)
+	// broj vodecih nula u ostatku hash-a + 1
+	rho := uint8(bits.LeadingZeros64(x<<hll.p|1<<(hll.p-1))) + 1
+	if rho > hll.registers[i] {
+		hll.registers[i] = rho
+	}
+}
+
+// procena broja razlicitih podataka
+func (hll *HyperLogLog) Estimate() float64 {
+	sum := 0.0
+	zeros := 0
+	for _, r := range hll.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hll.m)
+	estimate := hll.alpha() * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// korekcija za mali broj podataka (linear counting)
+		return m * math.Log(m/float64(zeros))
+	}
+	return estimate
+}
+
+// spajanje drugog HLL u ovaj, oba moraju imati istu preciznost
+func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
+	if hll.p != other.p {
+		return errors.New("nije moguce spojiti HLL razlicitih preciznosti")
+	}
+	for i, r := range other.registers {
+		if r > hll.registers[i] {
+			hll.registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (hll *HyperLogLog) alpha() float64 {
+	switch hll.m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(hll.m))
+}
+
+func (hll *HyperLogLog) Serialize(w io.Writer) int {
+	util.WriteUint(hll.p, w)
+	util.WriteBytes(hll.registers, w)
+	return 1 + int(hll.m)
+}
+
+// Preciznost se proverava pre citanja registara, nepotpun zapis vraca gresku
+func Deserialize(r io.Reader) (*HyperLogLog, error) {
+	p := make([]byte, 1)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	hll, err := New(p[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(r, hll.registers); err != nil {
+		return nil, errors.New("HLL je nepotpun")
+	}
+	return hll, nil
+}
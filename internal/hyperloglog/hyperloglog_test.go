@@ -0,0 +1,88 @@
+package hyperloglog
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		hll, err := New(12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			// svaki podatak se dodaje dva puta, procenjuje se broj razlicitih
+			hll.Add(fmt.Sprint(i))
+			hll.Add(fmt.Sprint(i))
+		}
+		// standardna greska je 1.04/sqrt(m), oko 1.6% za p=12
+		if estimate := hll.Estimate(); math.Abs(estimate-float64(n)) > 0.05*float64(n)+1 {
+			t.Errorf("procena %.0f za %d razlicitih podataka", estimate, n)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, _ := New(10)
+	b, _ := New(10)
+	for i := 0; i < 2000; i++ {
+		a.Add(fmt.Sprint("a", i))
+		b.Add(fmt.Sprint("b", i))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if estimate := a.Estimate(); math.Abs(estimate-4000) > 400 {
+		t.Errorf("procena %.0f posle spajanja, ocekivano oko 4000", estimate)
+	}
+
+	c, _ := New(11)
+	if err := a.Merge(c); err == nil {
+		t.Errorf("spojeni su HLL-ovi razlicitih preciznosti")
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	for _, p := range []uint8{0, MinPrecision - 1, MaxPrecision + 1} {
+		if _, err := New(p); err == nil {
+			t.Errorf("New(%d) nije vratio gresku", p)
+		}
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	hll, _ := New(8)
+	for i := 0; i < 500; i++ {
+		hll.Add(fmt.Sprint(i))
+	}
+	buf := new(bytes.Buffer)
+	if n := hll.Serialize(buf); n != buf.Len() {
+		t.Errorf("Serialize vraca %d, upisano %d bajtova", n, buf.Len())
+	}
+	loaded, err := Deserialize(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Estimate() != hll.Estimate() {
+		t.Errorf("procena %.0f posle deserijalizacije, ocekivano %.0f", loaded.Estimate(), hll.Estimate())
+	}
+
+	data := buf.Bytes()
+	invalid := []struct {
+		name string
+		data []byte
+	}{
+		{"prazan", nil},
+		{"neispravna preciznost", []byte{MaxPrecision + 1}},
+		{"bez registara", data[:1]},
+		{"nepotpuni registri", data[:len(data)-1]},
+	}
+	for _, test := range invalid {
+		if _, err := Deserialize(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: Deserialize nije vratio gresku", test.name)
+		}
+	}
+}
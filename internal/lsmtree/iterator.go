@@ -20,18 +20,28 @@ func newIterator(toc *sstable.TOC) *ssTableIterator {
 	}
 }
 
-func (it *ssTableIterator) Read() *sstable.DataElement {
+func (it *ssTableIterator) Read() (*sstable.DataElement, error) {
 	if it.file == nil {
-		return nil
+		return nil, nil
 	}
 
 	it.file.Seek(int64(it.position), 0)
-	record, b := sstable.ReadNextDataRecord(it.file)
+	record, b, err := sstable.ReadNextDataRecord(it.file, it.table.Toc.Version)
+	if err != nil {
+		it.Close()
+		return nil, err
+	}
 	it.position += b
 
 	if it.position >= it.table.Toc.DataSize {
+		it.Close()
+	}
+	return &record, nil
+}
+
+func (it *ssTableIterator) Close() {
+	if it.file != nil {
 		it.file.Close()
 		it.file = nil
 	}
-	return &record
 }
@@ -2,19 +2,23 @@ package lsmtree
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
 	"go-touch-grass/config"
 	"go-touch-grass/internal/bloom"
+	"go-touch-grass/internal/hash"
 	"go-touch-grass/internal/memtable"
+	"go-touch-grass/internal/merge"
+	"go-touch-grass/internal/merkle"
 	"go-touch-grass/internal/sstable"
 	"go-touch-grass/internal/summary"
-	"io"
 	"os"
 	fp "path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
 )
 
 type LSMTree struct {
@@ -39,6 +43,7 @@ func (lsm *LSMTree) LoadTocPaths(level int) []string {
 	if err != nil {
 		return []string{}
 	}
+	defer folder.Close()
 	content, err := folder.ReadDir(0)
 	if err != nil {
 		return []string{}
@@ -46,7 +51,7 @@ func (lsm *LSMTree) LoadTocPaths(level int) []string {
 	for _, v := range content {
 		temp := strings.Split(v.Name(), "-")
 		if temp[len(temp)-1] == "TOC.yaml" {
-			tocs = append(tocs, "./data/level-"+formatLevel(level)+"/"+v.Name())
+			tocs = append(tocs, fp.Join(lsm.levels[level-1], v.Name()))
 		}
 	}
 
@@ -108,17 +113,19 @@ func New(conf *config.Config, dataPath string) *LSMTree {
 
 	level, _ := data_folder.ReadDir(0)
 	lsm.levels = make([]string, 0)
-	if len(level) == 0 {
+	for i := 0; i < len(level); i++ {
+		// direktorijumi familija kolona se nalaze pored nivoa podrazumevane familije
+		if level[i].IsDir() && strings.HasPrefix(level[i].Name(), "level-") {
+			lsm.levels = append(lsm.levels, fp.Join(dataPath, level[i].Name()))
+		}
+	}
+	if len(lsm.levels) == 0 {
 		first_lvl := fp.Join(dataPath, "level-001")
 		_, err := os.Stat(first_lvl)
 		if err != nil {
 			os.Mkdir(first_lvl, 0755)
 		}
 		lsm.levels = append(lsm.levels, first_lvl)
-	} else {
-		for i := 0; i < len(level); i++ {
-			lsm.levels = append(lsm.levels, fp.Join(dataPath, level[i].Name()))
-		}
 	}
 	lsm.max_level = uint(conf.LsmMaxLevel)
 	lsm.level_size = uint(conf.LsmLevelSize)
@@ -126,21 +133,6 @@ func New(conf *config.Config, dataPath string) *LSMTree {
 	return lsm
 }
 
-func writeRecord(w io.Writer, rec *sstable.DataElement) uint64 {
-	n := 37
-	tsBytes := make([]byte, 16)
-	binary.BigEndian.PutUint64(tsBytes[:8], uint64(rec.Timestamp.Unix()))
-	binary.BigEndian.PutUint64(tsBytes[8:], uint64(rec.Timestamp.Nanosecond()))
-	binary.Write(w, binary.BigEndian, rec.CRC)
-	binary.Write(w, binary.BigEndian, tsBytes)
-	binary.Write(w, binary.BigEndian, rec.Tombstone)
-	binary.Write(w, binary.BigEndian, rec.KeySize)
-	binary.Write(w, binary.BigEndian, rec.ValueSize)
-	k, _ := w.Write([]byte(rec.Key))
-	v, _ := w.Write(rec.Value)
-	return uint64(n + k + v)
-}
-
 func getMinRecord(records []*sstable.DataElement) (int, []int) {
 	min := -1
 	for i, rec := range records {
@@ -149,8 +141,7 @@ func getMinRecord(records []*sstable.DataElement) (int, []int) {
 		} else if min == -1 {
 			min = i
 		} else if rec.Key < records[min].Key ||
-			(rec.Key == records[min].Key && rec.Timestamp.UnixNano() >
-				records[min].Timestamp.UnixNano()) {
+			(rec.Key == records[min].Key && isNewer(rec, records[min])) {
 			min = i
 		}
 	}
@@ -164,6 +155,14 @@ func getMinRecord(records []*sstable.DataElement) (int, []int) {
 	return min, toRefresh
 }
 
+// Noviji zapis ima vecu sekvencu, zapisi iz tabela bez sekvenci se porede po vremenu
+func isNewer(a *sstable.DataElement, b *sstable.DataElement) bool {
+	if a.Seq != b.Seq {
+		return a.Seq > b.Seq
+	}
+	return a.Timestamp.UnixNano() > b.Timestamp.UnixNano()
+}
+
 func (lsm *LSMTree) CompactLevel(level int) error {
 	toc_paths := lsm.LoadTocPaths(level)
 
@@ -186,9 +185,6 @@ func (lsm *LSMTree) CompactLevel(level int) error {
 		iterators[i] = newIterator(toc)
 	}
 
-	record_count := uint64(lsm.conf.MemtableCap * len(iterators))
-	bf := bloom.New(record_count, lsm.conf.FilterPrecision)
-
 	data_file, _ := os.Create(table.Toc.DataPath)
 	w := bufio.NewWriter(data_file)
 	defer data_file.Close()
@@ -197,29 +193,59 @@ func (lsm *LSMTree) CompactLevel(level int) error {
 	position := uint64(0)
 
 	for i, iterator := range iterators {
-		records[i] = iterator.Read()
+		records[i], err = iterator.Read()
+		if err != nil {
+			discardCompaction(iterators, data_file)
+			return err
+		}
 	}
+	now := time.Now()
+	older := lsm.lowerFilters(level)
 	for {
-		min, toRead := getMinRecord(records)
+		_, toRead := getMinRecord(records)
 		if len(toRead) == 0 {
 			break
 		}
 
-		rec := records[min]
-		bf.Add(rec.Key)
+		rec, err := lsm.compactKey(older, records, toRead, now)
+		if err != nil {
+			discardCompaction(iterators, data_file)
+			return err
+		}
+		if rec == nil {
+			// istekla vrednost ne zaklanja nijednu stariju pa se izbacuje
+			if err = readNext(iterators, records, toRead); err != nil {
+				discardCompaction(iterators, data_file)
+				return err
+			}
+			continue
+		}
 		keys = append(keys, rec.Key)
 		offsets = append(offsets, position)
-		position += writeRecord(w, rec)
+		written, err := sstable.WriteDataRecord(w, rec)
+		if err != nil {
+			discardCompaction(iterators, data_file)
+			return err
+		}
+		position += written
 		w.Flush()
 		w.Reset(data_file)
 
-		for _, i := range toRead {
-			records[i] = iterators[i].Read()
+		if err = readNext(iterators, records, toRead); err != nil {
+			// ostecen zapis se ne prepisuje u novi nivo
+			discardCompaction(iterators, data_file)
+			return err
 		}
 	}
 
 	table.Toc.DataSize = position
 
+	// broj kljuceva u novoj tabeli je poznat tek posle spajanja
+	bf := bloom.New(uint64(max(len(keys), 1)), lsm.conf.FilterPrecision)
+	for _, key := range keys {
+		bf.Add(key)
+	}
+
 	// Creating index segment
 	var ioffsets []uint64
 	if lsm.conf.SSTableAllInOne {
@@ -258,8 +284,8 @@ func (lsm *LSMTree) CompactLevel(level int) error {
 		table.Toc.FilterSize = uint64(bf.Serialize(bffile))
 		bffile.Close()
 	}
-	table.CreateTOC()
 	table.CreateMerkle(lsm.conf.MerkleChunkSize)
+	table.CreateTOC()
 	DeleteDirContent(lsm.levels[level-1])
 
 	if lsm.LevelFull(level + 1) {
@@ -268,49 +294,209 @@ func (lsm *LSMTree) CompactLevel(level int) error {
 	return err
 }
 
-func (lsm *LSMTree) GetFromMemtable(key string) (data []byte, deleted bool) {
-	// Povratna vrdnost podaci i da li je obrisan
+// Jedan zapis za kljuc od svih njegovih verzija u tabelama koje se kompaktuju, nil ako se kljuc izbacuje
+// Niz izmena za spajanje se spaja sa najnovijom osnovom, a bez osnove ostaje jedan zapis sa svim izmenama
+func (lsm *LSMTree) compactKey(older []*bloom.BloomFilter, records []*sstable.DataElement, indexes []int, now time.Time) (*sstable.DataElement, error) {
+	versions := make([]*sstable.DataElement, len(indexes))
+	for i, index := range indexes {
+		versions[i] = records[index]
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return isNewer(versions[i], versions[j])
+	})
+
+	rec := versions[0]
+	if !rec.Merge {
+		if !rec.Expired(now) {
+			return rec, nil
+		}
+		if !mayContainOlder(older, rec.Key) {
+			return nil, nil
+		}
+		// starija verzija kljuca mozda postoji na nizem nivou, cuva se samo oznaka brisanja
+		return &sstable.DataElement{
+			CRC:       hash.GetCrc(rec.Key, nil),
+			Seq:       rec.Seq,
+			Timestamp: rec.Timestamp,
+			Tombstone: true,
+			KeySize:   rec.KeySize,
+			Key:       rec.Key,
+		}, nil
+	}
+
+	var base *sstable.DataElement
+	var operands [][]byte
+	for _, v := range versions {
+		if !v.Merge {
+			base = v
+			break
+		}
+		older, err := merge.DecodeOperands(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(older, operands...)
+	}
+
+	merged := &sstable.DataElement{
+		Seq:       rec.Seq,
+		Timestamp: rec.Timestamp,
+		KeySize:   rec.KeySize,
+		Key:       rec.Key,
+	}
+	if base == nil && mayContainOlder(older, rec.Key) {
+		// osnova je mozda na nizem nivou
+		merged.Merge = true
+		merged.Value = merge.EncodeOperands(operands)
+	} else {
+		var value []byte
+		if base != nil && !base.Tombstone && !base.Expired(now) {
+			value = base.Value
+		}
+		var err error
+		if merged.Value, err = lsm.ApplyMerge(value, operands); err != nil {
+			return nil, err
+		}
+	}
+	merged.ValueSize = uint64(len(merged.Value))
+	merged.CRC = hash.GetCrc(merged.Key, merged.Value)
+	return merged, nil
+}
+
+func readNext(iterators []*ssTableIterator, records []*sstable.DataElement, toRead []int) (err error) {
+	for _, i := range toRead {
+		records[i], err = iterators[i].Read()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Filteri svih tabela ispod nivoa koji se kompaktuje, ucitavaju se jednom za celu kompakciju
+func (lsm *LSMTree) lowerFilters(level int) []*bloom.BloomFilter {
+	filters := make([]*bloom.BloomFilter, 0)
+	for i := level + 1; i <= len(lsm.levels); i++ {
+		for _, toc_path := range lsm.LoadTocPaths(i) {
+			filters = append(filters, sstable.GetSSTable(sstable.GetTOC(toc_path)).LoadBloomFilter())
+		}
+	}
+	return filters
+}
+
+// Da li neka tabela ispod nivoa koji se kompaktuje moze sadrzati kljuc
+func mayContainOlder(filters []*bloom.BloomFilter, key string) bool {
+	for _, bf := range filters {
+		if bf.Has(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prekid kompakcije, stare tabele ostaju netaknute a nova se brise
+func discardCompaction(iterators []*ssTableIterator, data_file *os.File) {
+	for _, it := range iterators {
+		it.Close()
+	}
+	data_file.Close()
+	os.Remove(data_file.Name())
+}
+
+// Povratna vrednost su podaci i da li je kljuc obrisan
+// Ako memtabela ima samo izmene za spajanje, one se vracaju kao operands i treba ih spojiti sa vrednoscu sa diska
+func (lsm *LSMTree) GetFromMemtable(key string) (data []byte, deleted bool, operands [][]byte) {
 	record, found := lsm.memtable.Get(key)
 	if found {
-		if !record.Tombstone {
-			return record.Data, false
+		if record.Merge {
+			operands, _ = merge.DecodeOperands(record.Data)
+			return nil, false, operands
 		}
-		return nil, true
+		if !record.Tombstone && !record.Expired(time.Now()) {
+			return record.Data, false, nil
+		}
+		return nil, true, nil
 	}
-	return nil, false
+	return nil, false, nil
 }
 
-func (lsm *LSMTree) GetFromDisc(key string) ([]byte, error) {
+// Vraca podatke i vreme isteka, obrisan ili istekao kljuc nema podatke
+// Izmene za spajanje iz novijih tabela se spajaju sa prvom starijom vrednoscu
+func (lsm *LSMTree) GetFromDisc(key string) ([]byte, time.Time, error) {
+	var operands [][]byte
 	for i := 1; i <= len(lsm.levels); i++ {
 		level := lsm.LoadTocPaths(i)
 		for j := len(level) - 1; j >= 0; j-- {
-			TOC := sstable.GetTOC(level[j])
-			table := sstable.GetSSTable(TOC)
-			if table.QueryBloomFilter(key) {
-				start, end := table.QuerySummary(key)
-				if start >= 0 && end >= 0 {
-					keyelm, err := table.Index.FindBetweenRange(key, start, end)
-					if err != nil {
-						return nil, err
-					}
-
-					if keyelm != nil {
-						data, deleted := table.Read(keyelm.Offset)
-						if deleted {
-							return nil, nil
-						}
-						return data, nil
-					}
+			rec, err := findInTable(sstable.GetSSTable(sstable.GetTOC(level[j])), key)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if rec == nil {
+				continue
+			}
+			if rec.Merge {
+				older, err := merge.DecodeOperands(rec.Value)
+				if err != nil {
+					return nil, time.Time{}, err
 				}
+				operands = append(older, operands...)
+				continue
+			}
+
+			var value []byte
+			if !rec.Tombstone && !rec.Expired(time.Now()) {
+				value = rec.Value
 			}
+			if len(operands) > 0 {
+				value, err = lsm.ApplyMerge(value, operands)
+				return value, time.Time{}, err
+			}
+			if value == nil {
+				return nil, time.Time{}, nil
+			}
+			return value, rec.Expires, nil
 		}
 	}
-	return nil, nil
+	if len(operands) > 0 {
+		value, err := lsm.ApplyMerge(nil, operands)
+		return value, time.Time{}, err
+	}
+	return nil, time.Time{}, nil
+}
+
+// Zapis kljuca iz tabele, nil ako ga tabela ne sadrzi
+func findInTable(table *sstable.SSTable, key string) (*sstable.DataElement, error) {
+	if !table.QueryBloomFilter(key) {
+		return nil, nil
+	}
+	start, end := table.QuerySummary(key)
+	if start < 0 || end < 0 {
+		return nil, nil
+	}
+	keyelm, err := table.Index.FindBetweenRange(key, start, end)
+	if err != nil || keyelm == nil {
+		return nil, err
+	}
+	rec, err := table.Read(keyelm.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Spaja izmene, redom od najstarije, sa vrednoscu base koristeci operator iz config fajla
+func (lsm *LSMTree) ApplyMerge(base []byte, operands [][]byte) ([]byte, error) {
+	op, err := merge.Get(lsm.conf.MergeOperator)
+	if err != nil {
+		return nil, err
+	}
+	return merge.Fold(op, base, operands)
 }
 
-func (lsm *LSMTree) Put(key string, data []byte) (err error, flushed bool) {
+// Nulta vrednost expires znaci da vrednost ne istice
+func (lsm *LSMTree) Put(key string, data []byte, expires time.Time, seq uint64) (err error, flushed bool) {
 	// Funkcija za stavljanje u memtable
-	err = lsm.memtable.Put(key, data)
+	err = lsm.memtable.PutWithExpiry(key, data, expires, seq)
 	if err != nil {
 		return
 	}
@@ -321,8 +507,9 @@ func (lsm *LSMTree) Put(key string, data []byte) (err error, flushed bool) {
 	return
 }
 
-func (lsm *LSMTree) Delete(key string) (err error, flushed bool) {
-	err = lsm.memtable.Delete(key)
+// Izmena koja se kasnije spaja sa vrednoscu kljuca
+func (lsm *LSMTree) Merge(key string, operand []byte, seq uint64) (err error, flushed bool) {
+	err = lsm.mergeIntoMemtable(key, operand, seq)
 	if err != nil {
 		return
 	}
@@ -334,6 +521,68 @@ func (lsm *LSMTree) Delete(key string) (err error, flushed bool) {
 	return
 }
 
+// Ako memtabela ima vrednost kljuca izmena se odmah spaja sa njom, inace se dodaje ostalim izmenama kljuca
+func (lsm *LSMTree) mergeIntoMemtable(key string, operand []byte, seq uint64) error {
+	record, found := lsm.memtable.Get(key)
+	if !found {
+		return lsm.memtable.PutMerge(key, merge.EncodeOperands([][]byte{operand}), seq)
+	}
+	if record.Merge {
+		operands, err := merge.DecodeOperands(record.Data)
+		if err != nil {
+			return err
+		}
+		return lsm.memtable.PutMerge(key, merge.EncodeOperands(append(operands, operand)), seq)
+	}
+
+	var base []byte
+	if !record.Tombstone && !record.Expired(time.Now()) {
+		base = record.Data
+	}
+	value, err := lsm.ApplyMerge(base, [][]byte{operand})
+	if err != nil {
+		return err
+	}
+	return lsm.memtable.Put(key, value, seq)
+}
+
+func (lsm *LSMTree) Delete(key string, seq uint64) (err error, flushed bool) {
+	err = lsm.memtable.Delete(key, seq)
+	if err != nil {
+		return
+	}
+
+	if lsm.memtable.IsFull() {
+		err = lsm.FlushMemtable()
+		flushed = true
+	}
+	return
+}
+
+// Izmena iz WAL-a pri oporavku, memtabela se ne prazni
+func (lsm *LSMTree) Replay(key string, data []byte, tombstone bool, expires time.Time, seq uint64) error {
+	if tombstone {
+		return lsm.memtable.Delete(key, seq)
+	}
+	return lsm.memtable.PutWithExpiry(key, data, expires, seq)
+}
+
+func (lsm *LSMTree) ReplayMerge(key string, operand []byte, seq uint64) error {
+	return lsm.mergeIntoMemtable(key, operand, seq)
+}
+
+func (lsm *LSMTree) OldestSeq() (uint64, bool) {
+	return lsm.memtable.OldestSeq()
+}
+
+func (lsm *LSMTree) MemtableFull() bool {
+	return lsm.memtable.IsFull()
+}
+
+func (lsm *LSMTree) ClearMemtable() {
+	lsm.memtable.Clear()
+}
+
 func (lsm *LSMTree) FlushMemtable() error {
 	// Treba proveriti svaki nivo da li je slucajno dosao do prekoracenja
 	table, err := sstable.NewSSTable(lsm.conf, lsm.dataPath, "level-001")
@@ -353,11 +602,120 @@ func (lsm *LSMTree) FlushMemtable() error {
 	return err
 }
 
+func (lsm *LSMTree) VerifyIntegrity() ([]sstable.IntegrityReport, error) {
+	reports := make([]sstable.IntegrityReport, 0)
+	for i := 1; i <= len(lsm.levels); i++ {
+		for _, toc_path := range lsm.LoadTocPaths(i) {
+			table := sstable.GetSSTable(sstable.GetTOC(toc_path))
+			corrupted, err := table.VerifyMerkle(lsm.conf.MerkleChunkSize)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, sstable.IntegrityReport{
+				TOCPath:   toc_path,
+				Corrupted: corrupted,
+			})
+		}
+	}
+	return reports, nil
+}
+
+// Koreni Merkle stabala svih SSTabela, redom po nivoima
+func (lsm *LSMTree) TableHashes() ([]*merkle.Node, error) {
+	leafs := make([]*merkle.Node, 0)
+	for i := 1; i <= len(lsm.levels); i++ {
+		for _, toc_path := range lsm.LoadTocPaths(i) {
+			table := sstable.GetSSTable(sstable.GetTOC(toc_path))
+			root, err := table.GetMerkleRoot()
+			if err != nil {
+				return nil, err
+			}
+			leafs = append(leafs, merkle.NewLeaf(root))
+		}
+	}
+	return leafs, nil
+}
+
+// Poredjenje SSTabela sa SSTabelama iz drugog direktorijuma podataka
+func (lsm *LSMTree) CompareWith(otherDataPath string) ([]sstable.DiffReport, error) {
+	names, err := getTableNames(lsm.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	other_names, err := getTableNames(otherDataPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range other_names {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.StringSlice.Sort(names)
+
+	reports := make([]sstable.DiffReport, 0)
+	for _, name := range names {
+		table, err := loadMovedTable(lsm.dataPath, name)
+		if err != nil {
+			return nil, err
+		}
+		other, err := loadMovedTable(otherDataPath, name)
+		if err != nil {
+			return nil, err
+		}
+
+		report := sstable.DiffReport{Table: name}
+		if table == nil || other == nil {
+			if table == nil {
+				table = other
+			}
+			report.Missing = true
+			report.Chunks = table.GetAllChunks(lsm.conf.MerkleChunkSize)
+		} else {
+			report.Chunks, err = table.DiffMerkle(other, lsm.conf.MerkleChunkSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(report.Chunks) > 0 || report.Missing {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// Nazivi tabela oblika level-001/usertable-001 u direktorijumu podataka
+func getTableNames(dataPath string) ([]string, error) {
+	toc_paths, err := fp.Glob(fp.Join(dataPath, "level-*", "*-TOC.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(toc_paths))
+	for i, toc_path := range toc_paths {
+		name, _ := fp.Rel(dataPath, toc_path)
+		names[i] = strings.TrimSuffix(fp.ToSlash(name), "-TOC.yaml")
+	}
+	return names, nil
+}
+
+func loadMovedTable(dataPath string, name string) (*sstable.SSTable, error) {
+	toc_path := fp.Join(dataPath, fp.FromSlash(name)+"-TOC.yaml")
+	if _, err := os.Stat(toc_path); err != nil {
+		return nil, nil
+	}
+	toc, err := sstable.GetMovedTOC(toc_path)
+	if err != nil {
+		return nil, err
+	}
+	return sstable.GetSSTable(toc), nil
+}
+
 func DeleteDirContent(path string) {
 	d, err := os.Open(path)
 	if err != nil {
 		panic(err)
 	}
+	defer d.Close()
 	files, err := d.ReadDir(0)
 	if err != nil {
 		panic(err)
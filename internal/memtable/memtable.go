@@ -19,16 +19,24 @@ type Container interface {
 }
 
 type Record struct {
+	Seq       uint64
 	Crc       uint32
 	Timestamp time.Time
 	Tombstone bool
+	Merge     bool      // Data sadrzi izmene koje treba spojiti sa starijom vrednoscu
+	Expires   time.Time // nulta vrednost znaci da vrednost ne istice
 	Key       string
 	Data      []byte
 }
 
+// Memtabela nije bezbedna za istovremenu upotrebu ni sa kontejnerom concurrent-skiplist,
+// jer se kljuc prvo cita pa upisuje i velicina se racuna bez zakljucavanja, operacije se izvrsavaju pod app.mu
 type Memtable struct {
-	table Container
-	cap   int
+	table    Container
+	cap      int   // najveci broj kljuceva, 0 znaci da broj nije ogranicen
+	maxBytes int64 // najveca ukupna velicina kljuceva i vrednosti
+	bytes    int64
+	oldest   uint64 // najmanja sekvenca upisana od poslednjeg praznjenja
 }
 
 func New(c *conf.Config) *Memtable {
@@ -37,36 +45,59 @@ func New(c *conf.Config) *Memtable {
 	switch c.MemtableContainer {
 	case "skiplist":
 		table = skiplist.New(c.SkiplistMaxHeight)
+	case "concurrent-skiplist":
+		table = skiplist.NewConcurrent(c.SkiplistMaxHeight)
 	case "btree":
 		table = btree.New(c.BtreeDegree)
 	default:
 		panic("error in config file (MemtableContainer field)")
 	}
-	return &Memtable{table, c.MemtableCap}
+	return &Memtable{table: table, cap: c.MemtableCap, maxBytes: c.MemtableMaxBytes}
 }
 
-func (mt *Memtable) putRecord(key string, data []byte, tombstone bool) error {
-	_, contains := mt.table.Get(key)
+// Istekla vrednost se tretira kao obrisana
+func (r *Record) Expired(now time.Time) bool {
+	return !r.Expires.IsZero() && !now.Before(r.Expires)
+}
+
+// Velicina zapisa koja se racuna u kapacitet memtabele
+func (r *Record) size() int64 {
+	return int64(len(r.Key) + len(r.Data))
+}
+
+func (mt *Memtable) putRecord(record Record) error {
+	old, contains := mt.Get(record.Key)
 	if !contains && mt.IsFull() {
 		return errors.New("pokusaj dodavanja u punu memoriju")
 	}
-	record := Record{
-		Crc:       hash.GetCrc(key, data),
-		Timestamp: time.Now(),
-		Tombstone: tombstone,
-		Key:       key,
-		Data:      data,
+	if contains {
+		mt.bytes -= old.size()
+	}
+	mt.bytes += record.size()
+	if mt.table.Size() == 0 || record.Seq < mt.oldest {
+		mt.oldest = record.Seq
 	}
-	mt.table.Put(key, record)
+	record.Crc = hash.GetCrc(record.Key, record.Data)
+	record.Timestamp = time.Now()
+	mt.table.Put(record.Key, record)
 	return nil
 }
 
-func (mt *Memtable) Put(key string, data []byte) error {
-	return mt.putRecord(key, data, false)
+func (mt *Memtable) Put(key string, data []byte, seq uint64) error {
+	return mt.putRecord(Record{Seq: seq, Key: key, Data: data})
 }
 
-func (mt *Memtable) Delete(key string) error {
-	return mt.putRecord(key, nil, true)
+func (mt *Memtable) PutWithExpiry(key string, data []byte, expires time.Time, seq uint64) error {
+	return mt.putRecord(Record{Seq: seq, Expires: expires, Key: key, Data: data})
+}
+
+func (mt *Memtable) Delete(key string, seq uint64) error {
+	return mt.putRecord(Record{Seq: seq, Tombstone: true, Key: key})
+}
+
+// Izmene za spajanje ciju osnovu memtabela nema, operands je rezultat merge.EncodeOperands
+func (mt *Memtable) PutMerge(key string, operands []byte, seq uint64) error {
+	return mt.putRecord(Record{Seq: seq, Merge: true, Key: key, Data: operands})
 }
 
 func (mt *Memtable) Get(key string) (Record, bool) {
@@ -77,12 +108,20 @@ func (mt *Memtable) Get(key string) (Record, bool) {
 	return data.(Record), true
 }
 
+// Najmanja sekvenca cije izmene jos nisu u SSTabelama, zapis kljuca cuva samo najnoviju
+func (mt *Memtable) OldestSeq() (uint64, bool) {
+	return mt.oldest, mt.table.Size() > 0
+}
+
+// Memtabela je puna kada velicina kljuceva i vrednosti dostigne MemtableMaxBytes
+// ili broj kljuceva dostigne MemtableCap, ako je zadat
 func (mt *Memtable) IsFull() bool {
-	return mt.table.Size() >= mt.cap
+	return mt.bytes >= mt.maxBytes || (mt.cap > 0 && mt.table.Size() >= mt.cap)
 }
 
 func (mt *Memtable) Clear() {
 	mt.table.Clear()
+	mt.bytes = 0
 }
 
 func (mt *Memtable) GetAll() []Record {
@@ -103,21 +142,21 @@ func (mt *Memtable) Print() {
 
 func GetExample(config *conf.Config) *Memtable {
 	mem := New(config)
-	mem.Put("aaa", []byte("aaa"))
-	mem.Put("bbb", []byte("bbb"))
-	mem.Put("ccc", []byte("ccc"))
-	mem.Put("ddd", []byte("ddd"))
-	mem.Put("eee", []byte("eee"))
-	mem.Put("fff", []byte("fff"))
-	mem.Put("ggg", []byte("ggg"))
-	mem.Put("hhh", []byte("hhh"))
-	mem.Put("iii", []byte("iii"))
-	mem.Put("jjj", []byte("jjj"))
-
-	mem.Put("ccc", []byte("yyy"))
-	mem.Put("ddd", []byte("zzz"))
-
-	mem.Delete("aaa")
-	mem.Delete("bbb")
+	mem.Put("aaa", []byte("aaa"), 1)
+	mem.Put("bbb", []byte("bbb"), 2)
+	mem.Put("ccc", []byte("ccc"), 3)
+	mem.Put("ddd", []byte("ddd"), 4)
+	mem.Put("eee", []byte("eee"), 5)
+	mem.Put("fff", []byte("fff"), 6)
+	mem.Put("ggg", []byte("ggg"), 7)
+	mem.Put("hhh", []byte("hhh"), 8)
+	mem.Put("iii", []byte("iii"), 9)
+	mem.Put("jjj", []byte("jjj"), 10)
+
+	mem.Put("ccc", []byte("yyy"), 11)
+	mem.Put("ddd", []byte("zzz"), 12)
+
+	mem.Delete("aaa", 13)
+	mem.Delete("bbb", 14)
 	return mem
 }
@@ -0,0 +1,132 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Spaja vrednost kljuca sa jednom izmenom, nil base znaci da kljuc ne postoji
+// Poziv sa nil base sluzi i za proveru izmene pre upisa, pa neispravna izmena mora vratiti gresku
+// Osnova koju operator ne razume tretira se kao prazna, da spajanje ne bi zavisilo od ranijih upisa
+type Operator func(base []byte, operand []byte) ([]byte, error)
+
+var (
+	mu        sync.RWMutex
+	operators = map[string]Operator{
+		"add":              Add,
+		"append":           Append,
+		"json-merge-patch": JsonMergePatch,
+	}
+)
+
+// Registracija operatora pod imenom koje se navodi u config fajlu (MergeOperator)
+func Register(name string, op Operator) {
+	mu.Lock()
+	defer mu.Unlock()
+	operators[name] = op
+}
+
+func Get(name string) (Operator, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	op, ok := operators[name]
+	if !ok {
+		return nil, fmt.Errorf("merge operator %s nije registrovan", name)
+	}
+	return op, nil
+}
+
+// Primenjuje izmene redom od najstarije do najnovije
+func Fold(op Operator, base []byte, operands [][]byte) ([]byte, error) {
+	var err error
+	for _, operand := range operands {
+		base, err = op(base, operand)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return base, nil
+}
+
+// Sabiranje celih brojeva zapisanih kao tekst
+func Add(base []byte, operand []byte) ([]byte, error) {
+	delta, err := strconv.ParseInt(string(operand), 10, 64)
+	if err != nil {
+		return nil, errors.New("izmena za add mora biti ceo broj")
+	}
+	value, _ := strconv.ParseInt(string(base), 10, 64)
+	return []byte(strconv.FormatInt(value+delta, 10)), nil
+}
+
+// Dodavanje JSON vrednosti na kraj JSON niza
+func Append(base []byte, operand []byte) ([]byte, error) {
+	var item json.RawMessage
+	if err := json.Unmarshal(operand, &item); err != nil {
+		return nil, errors.New("izmena za append mora biti JSON vrednost")
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(base, &list) != nil {
+		list = nil
+	}
+	return json.Marshal(append(list, item))
+}
+
+// JSON Merge Patch (RFC 7396)
+func JsonMergePatch(base []byte, operand []byte) ([]byte, error) {
+	var patch interface{}
+	if err := json.Unmarshal(operand, &patch); err != nil {
+		return nil, errors.New("izmena za json-merge-patch mora biti JSON vrednost")
+	}
+	var target interface{}
+	if json.Unmarshal(base, &target) != nil {
+		target = nil
+	}
+	return json.Marshal(mergePatch(target, patch))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	result, ok := target.(map[string]interface{})
+	if !ok {
+		result = make(map[string]interface{})
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(result, name)
+		} else {
+			result[name] = mergePatch(result[name], value)
+		}
+	}
+	return result
+}
+
+// Vise izmena istog kljuca se cuva kao jedna vrednost, svaka izmena sa duzinom ispred
+func EncodeOperands(operands [][]byte) []byte {
+	buf := new(bytes.Buffer)
+	for _, operand := range operands {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(operand))))
+		buf.Write(operand)
+	}
+	return buf.Bytes()
+}
+
+func DecodeOperands(data []byte) ([][]byte, error) {
+	operands := make([][]byte, 0)
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, errors.New("neispravan zapis izmena za spajanje")
+		}
+		operands = append(operands, data[n:n+int(size)])
+		data = data[n+int(size):]
+	}
+	return operands, nil
+}
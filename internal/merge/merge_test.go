@@ -0,0 +1,66 @@
+package merge
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		op       Operator
+		base     string
+		operands []string
+		want     string
+	}{
+		{Add, "", []string{"5", "-2", "10"}, "13"},
+		{Add, "abc", []string{"1"}, "1"},
+		{Append, `["a"]`, []string{`"b"`, `{"c":1}`}, `["a","b",{"c":1}]`},
+		{JsonMergePatch, `{"a":"b","c":{"d":"e","f":"g"}}`, []string{`{"a":"z","c":{"f":null}}`}, `{"a":"z","c":{"d":"e"}}`},
+		{JsonMergePatch, `["a"]`, []string{`{"a":{"b":"c"}}`, `{"a":{"bb":null}}`}, `{"a":{"b":"c"}}`},
+	}
+	for _, test := range tests {
+		operands := make([][]byte, len(test.operands))
+		for i, o := range test.operands {
+			operands[i] = []byte(o)
+		}
+		var base []byte
+		if test.base != "" {
+			base = []byte(test.base)
+		}
+		got, err := Fold(test.op, base, operands)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("Fold(%s, %v) = %s, ocekivano %s", test.base, test.operands, got, test.want)
+		}
+	}
+}
+
+func TestInvalidOperand(t *testing.T) {
+	if _, err := Add(nil, []byte("x")); err == nil {
+		t.Error("ocekivana greska za add")
+	}
+	if _, err := Append(nil, []byte("{")); err == nil {
+		t.Error("ocekivana greska za append")
+	}
+}
+
+func TestOperands(t *testing.T) {
+	operands := [][]byte{[]byte("1"), {}, bytes.Repeat([]byte("x"), 300)}
+	got, err := DecodeOperands(EncodeOperands(operands))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(operands) {
+		t.Fatalf("procitano %d izmena, ocekivano %d", len(got), len(operands))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], operands[i]) {
+			t.Errorf("izmena %d: %q, ocekivano %q", i, got[i], operands[i])
+		}
+	}
+	if _, err = DecodeOperands([]byte{5, 'a'}); err == nil {
+		t.Error("ocekivana greska za skracen zapis")
+	}
+}
@@ -1,20 +1,47 @@
 package merkle
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
+	"go-touch-grass/internal/util"
 	"os"
 	"strings"
 )
 
+/*
+   +-------------+-----------------+------------------+------------+-...-+
+   | Magic (4B)  | Chunk Size (4B) | Leaf Count (4B)  | Leaf (20B) | ... |
+   +-------------+-----------------+------------------+------------+-...-+
+   Cuvaju se samo listovi, unutrasnji cvorovi se racunaju pri ucitavanju
+*/
+
+var magic = []byte("MRKL")
+
+const (
+	HashSize   = 20
+	headerSize = 12
+)
+
 type MerkleRoot struct {
-	root *Node
+	root      *Node
+	chunkSize int
 }
 
 func (mr *MerkleRoot) String() string {
 	return mr.root.String()
 }
 
+func (mr *MerkleRoot) RootHash() []byte {
+	return mr.root.data
+}
+
+// Velicina dela podataka nad kojim je racunat jedan list, 0 ako nije poznata
+func (mr *MerkleRoot) ChunkSize() int {
+	return mr.chunkSize
+}
+
 type Node struct {
 	data  []byte
 	left  *Node
@@ -24,6 +51,10 @@ type Node struct {
 func (n *Node) String() string {
 	return hex.EncodeToString(n.data[:])
 }
+
+func (n *Node) Hash() []byte {
+	return n.data
+}
 func ToHex(data string) []byte {
 	v, _ := hex.DecodeString(data)
 	return v
@@ -38,9 +69,11 @@ func newEmptyNode() *Node {
 func newNode(hash []byte, leftc *Node, rightc *Node) *Node {
 	return &Node{data: hash, left: leftc, right: rightc}
 }
-func NewMerkleTree(leafs []*Node) *MerkleRoot {
-
-	root := &MerkleRoot{root: getMerkleRoot(leafs)}
+func NewMerkleTree(leafs []*Node, chunkSize int) *MerkleRoot {
+	if len(leafs) == 0 {
+		return &MerkleRoot{root: newEmptyNode(), chunkSize: chunkSize}
+	}
+	root := &MerkleRoot{root: getMerkleRoot(leafs), chunkSize: chunkSize}
 	return root
 }
 func getMerkleRoot(nodes []*Node) *Node {
@@ -67,35 +100,93 @@ func GetLeaf(data []byte) *Node {
 	return &Node{data: hash[:], left: nil, right: nil}
 }
 
+// List ciji je hash vec izracunat
+func NewLeaf(hash []byte) *Node {
+	return &Node{data: hash, left: nil, right: nil}
+}
+
 func (m *MerkleRoot) Save(filepath string) {
-	var result []string
-	serializeMerkle(m.root, &result)
-	err := writeMerkle(filepath, strings.Join(result, "\n"))
+	leaves := m.Leaves()
+	buf := new(bytes.Buffer)
+	buf.Write(magic)
+	util.WriteUint(uint32(m.chunkSize), buf)
+	util.WriteUint(uint32(len(leaves)), buf)
+	for _, l := range leaves {
+		buf.Write(l)
+	}
+	err := os.WriteFile(filepath, buf.Bytes(), 0644)
 	if err != nil {
 		panic(err)
 	}
 }
 
 func TryLoad(filepath string) *MerkleRoot {
-	data, err := os.ReadFile(filepath)
+	m, err := Load(filepath)
 	if err != nil {
 		panic(err)
 	}
-	m := &MerkleRoot{}
-	m.root = readMerkle(string(data))
 	return m
 }
 
-func serializeMerkle(node *Node, result *[]string) {
+func Load(filepath string) (*MerkleRoot, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, magic) {
+		// stari tekstualni format sa celim stablom
+		return &MerkleRoot{root: readMerkle(string(data))}, nil
+	}
+
+	r := bytes.NewReader(data[len(magic):])
+	chunkSize, _ := util.ReadUint32(r)
+	count, _ := util.ReadUint32(r)
+	if len(data) != headerSize+int(count)*HashSize {
+		return nil, errors.New("neispravan fajl Merkle stabla " + filepath)
+	}
+	leafs := make([]*Node, count)
+	for i := range leafs {
+		hash, _ := util.ReadBytes(HashSize, r)
+		leafs[i] = NewLeaf(hash)
+	}
+	return NewMerkleTree(leafs, int(chunkSize)), nil
+}
+
+// Hash-evi listova redom, bez praznih cvorova dodatih kao dopuna
+func (m *MerkleRoot) Leaves() [][]byte {
+	leaves := make([][]byte, 0)
+	collectLeaves(m.root, height(m.root), &leaves)
+	return leaves
+}
+
+// Dopuna se dodaje samo sa desne strane, pa je leva grana uvek najduza
+func height(node *Node) int {
+	h := 0
+	for node != nil && node.left != nil {
+		node = node.left
+		h++
+	}
+	return h
+}
+
+func collectLeaves(node *Node, depth int, leaves *[][]byte) {
 	if node == nil {
-		*result = append(*result, "nil")
 		return
 	}
-	*result = append(*result, node.String())
-	serializeMerkle(node.left, result)
-	serializeMerkle(node.right, result)
+	if depth == 0 {
+		if !isEmpty(node) {
+			*leaves = append(*leaves, node.data)
+		}
+		return
+	}
+	collectLeaves(node.left, depth-1, leaves)
+	collectLeaves(node.right, depth-1, leaves)
+}
 
+func isEmpty(node *Node) bool {
+	return bytes.Equal(node.data, newEmptyNode().data)
 }
+
 func readMerkle(data string) *Node {
 	nodes := strings.Split(data, "\n")
 	index := 0
@@ -114,6 +205,51 @@ func deserializeMerkle(nodes []string, index *int) *Node {
 	return newNode
 }
 
-func writeMerkle(filepath string, result string) error {
-	return os.WriteFile(filepath, []byte(result), 0644)
+// Poredjenje dva stabla od korena ka listovima, podstabla sa istim hash-om se preskacu
+// Vraca indekse listova koji se razlikuju
+func Diff(a *MerkleRoot, b *MerkleRoot) []int {
+	diff := make([]int, 0)
+	ra, rb := a.root, b.root
+	ha, hb := height(ra), height(rb)
+	// nizem stablu se dodaju koreni bez hash-a ciji je levi potomak stari koren,
+	// pa su listovi sa istim indeksom na istom mestu u oba stabla
+	for ; ha < hb; ha++ {
+		ra = &Node{left: ra}
+	}
+	for ; hb < ha; hb++ {
+		rb = &Node{left: rb}
+	}
+	diffNodes(ra, rb, 0, ha, &diff)
+	return diff
+}
+
+func diffNodes(a *Node, b *Node, index int, depth int, diff *[]int) {
+	if a == nil && b == nil {
+		return
+	}
+	if a != nil && b != nil && bytes.Equal(a.data, b.data) {
+		return
+	}
+	if depth == 0 {
+		if (a != nil && !isEmpty(a)) || (b != nil && !isEmpty(b)) {
+			*diff = append(*diff, index)
+		}
+		return
+	}
+	diffNodes(a.getLeft(), b.getLeft(), 2*index, depth-1, diff)
+	diffNodes(a.getRight(), b.getRight(), 2*index+1, depth-1, diff)
+}
+
+func (n *Node) getLeft() *Node {
+	if n == nil {
+		return nil
+	}
+	return n.left
+}
+
+func (n *Node) getRight() *Node {
+	if n == nil {
+		return nil
+	}
+	return n.right
 }
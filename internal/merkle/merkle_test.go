@@ -0,0 +1,90 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+)
+
+func getTree(chunks []string) *MerkleRoot {
+	leafs := make([]*Node, len(chunks))
+	for i, c := range chunks {
+		leafs[i] = GetLeaf([]byte(c))
+	}
+	return NewMerkleTree(leafs, 0)
+}
+
+func getChunks(n int) []string {
+	chunks := make([]string, n)
+	for i := range chunks {
+		chunks[i] = fmt.Sprintf("chunk-%d", i)
+	}
+	return chunks
+}
+
+func equalIndexes(got []int, want []int) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLeaves(t *testing.T) {
+	for _, n := range []int{1, 2, 5, 8} {
+		if got := len(getTree(getChunks(n)).Leaves()); got != n {
+			t.Errorf("leaves: got %d, want %d", got, n)
+		}
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	a := getTree(getChunks(7))
+	b := getTree(getChunks(7))
+	if diff := Diff(a, b); len(diff) != 0 {
+		t.Errorf("equal trees differ at %v", diff)
+	}
+}
+
+func TestDiffChanged(t *testing.T) {
+	chunks := getChunks(7)
+	a := getTree(chunks)
+	chunks[1] = "changed"
+	chunks[6] = "changed"
+	b := getTree(chunks)
+
+	if diff := Diff(a, b); !equalIndexes(diff, []int{1, 6}) {
+		t.Errorf("got %v, want [1 6]", diff)
+	}
+}
+
+func TestDiffAppended(t *testing.T) {
+	a := getTree(getChunks(5))
+	b := getTree(getChunks(7))
+
+	if diff := Diff(a, b); !equalIndexes(diff, []int{5, 6}) {
+		t.Errorf("got %v, want [5 6]", diff)
+	}
+}
+
+func TestDiffDifferentHeight(t *testing.T) {
+	a := getTree(getChunks(4))
+	b := getTree(getChunks(5))
+	if diff := Diff(a, b); !equalIndexes(diff, []int{4}) {
+		t.Errorf("got %v, want [4]", diff)
+	}
+	if diff := Diff(b, a); !equalIndexes(diff, []int{4}) {
+		t.Errorf("got %v, want [4]", diff)
+	}
+
+	chunks := getChunks(9)
+	chunks[1] = "changed"
+	b = getTree(chunks)
+	a = getTree(getChunks(3))
+	if diff := Diff(a, b); !equalIndexes(diff, []int{1, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("got %v, want [1 3 4 5 6 7 8]", diff)
+	}
+}
@@ -0,0 +1,61 @@
+package simhash
+
+import (
+	"encoding/binary"
+	"go-touch-grass/internal/hash"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// svi otisci koriste istu hash f-iju kako bi mogli da se porede
+var token_hash = hash.SeededHash{Seed: []byte("simhash")}
+
+// racunanje 64-bitnog otiska teksta, tezina reci je broj njenih pojavljivanja
+func Fingerprint(text string) uint64 {
+	var sums [64]int
+	for token, weight := range getWeights(text) {
+		h := token_hash.Hash([]byte(token))
+		for i := 0; i < 64; i++ {
+			if h&(1<<i) != 0 {
+				sums[i] += weight
+			} else {
+				sums[i] -= weight
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for i, s := range sums {
+		if s > 0 {
+			fingerprint |= 1 << i
+		}
+	}
+	return fingerprint
+}
+
+// broj bitova u kojima se dva otiska razlikuju
+func HammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func getWeights(text string) map[string]int {
+	weights := make(map[string]int)
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, t := range tokens {
+		weights[t]++
+	}
+	return weights
+}
+
+func Serialize(fingerprint uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, fingerprint)
+	return b
+}
+
+func Deserialize(data []byte) uint64 {
+	return binary.BigEndian.Uint64(data)
+}
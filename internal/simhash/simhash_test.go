@@ -0,0 +1,44 @@
+package simhash
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	text := "the quick brown fox jumps over the lazy dog near the river bank"
+	tests := []struct {
+		name    string
+		other   string
+		maxDist int
+	}{
+		{"isti tekst", text, 0},
+		{"velika slova i interpunkcija", "The quick, brown fox jumps over the LAZY dog; near the river bank!", 0},
+		{"jedna rec razlicita", "the quick brown fox jumps over the lazy cat near the river bank", 16},
+	}
+	for _, test := range tests {
+		if d := HammingDistance(Fingerprint(text), Fingerprint(test.other)); d > test.maxDist {
+			t.Errorf("%s: udaljenost %d, ocekivano najvise %d", test.name, d, test.maxDist)
+		}
+	}
+
+	similar := HammingDistance(Fingerprint(text), Fingerprint(tests[2].other))
+	different := HammingDistance(Fingerprint(text), Fingerprint("lorem ipsum dolor sit amet consectetur adipiscing elit sed do"))
+	if similar >= different {
+		t.Errorf("slican tekst je na udaljenosti %d, a razlicit na %d", similar, different)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	if d := HammingDistance(0, ^uint64(0)); d != 64 {
+		t.Errorf("HammingDistance(0, max) = %d", d)
+	}
+	if d := HammingDistance(0b1010, 0b0110); d != 2 {
+		t.Errorf("HammingDistance(1010, 0110) = %d", d)
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	fingerprint := Fingerprint("serijalizacija otiska")
+	data := Serialize(fingerprint)
+	if len(data) != 8 || Deserialize(data) != fingerprint {
+		t.Errorf("Deserialize(Serialize(%x)) = %x", fingerprint, Deserialize(data))
+	}
+}
@@ -1,13 +1,52 @@
 package sstable
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type DataElement struct {
 	CRC       uint32
+	Seq       uint64
 	Timestamp time.Time
 	Tombstone bool
+	Merge     bool // Value sadrzi izmene koje treba spojiti sa starijom vrednoscu
+	Expires   time.Time
 	KeySize   uint64
 	Key       string
 	ValueSize uint64
 	Value     []byte
 }
+
+// Istekla vrednost se tretira kao obrisana
+func (e *DataElement) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Deo data segmenta nad kojim je izracunat jedan list Merkle stabla
+type Chunk struct {
+	Index int
+	Start uint64
+	End   uint64
+}
+
+type IntegrityReport struct {
+	TOCPath   string
+	Corrupted []Chunk
+}
+
+type DiffReport struct {
+	Table   string
+	Missing bool // tabela postoji samo u jednom direktorijumu
+	Chunks  []Chunk
+}
+
+// Greska koja se vraca kada CRC zapisa ne odgovara njegovom sadrzaju
+type CorruptionError struct {
+	Path   string
+	Offset int64
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("ostecen zapis u tabeli %s na poziciji %d (neispravan CRC)", e.Path, e.Offset)
+}
@@ -5,14 +5,17 @@ package sstable
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	conf "go-touch-grass/config"
 	"go-touch-grass/internal/bloom"
+	"go-touch-grass/internal/hash"
 	"go-touch-grass/internal/memtable"
 	"go-touch-grass/internal/merkle"
 	"go-touch-grass/internal/summary"
-	"go-touch-grass/internal/util"
+	"io"
 	"os"
 	fp "path/filepath"
 	"strconv"
@@ -23,6 +26,7 @@ import (
 )
 
 type TOC struct {
+	Version       int
 	DataPath      string
 	DataSize      uint64
 	FilterPath    string
@@ -35,6 +39,7 @@ type TOC struct {
 	SummaryOffset int64
 	SummarySize   uint64
 	MetadataPath  string
+	MerkleRoot    string
 }
 
 type SSTable struct {
@@ -54,13 +59,13 @@ func NewSSTable(conf *conf.Config, dataPath string, level string) (*SSTable, err
 	}
 
 	gen := fmt.Sprintf("%03d", gen_index)
-	table.Toc = &TOC{}
+	table.Toc = &TOC{Version: TableVersion}
 	if !conf.SSTableAllInOne {
 		table.Toc.DataPath = table.FilePathBase + gen + "-data.db"
 		table.Toc.FilterPath = table.FilePathBase + gen + "-filter.db"
 		table.Toc.SummaryPath = table.FilePathBase + gen + "-summary.db"
 		table.TOCFilePath = table.FilePathBase + gen + "-TOC.yaml"
-		table.Toc.MetadataPath = table.FilePathBase + gen + "-metadata.txt"
+		table.Toc.MetadataPath = table.FilePathBase + gen + "-metadata.db"
 		table.Index = NewIndex(table.FilePathBase+gen+"-index.db", 0, 0)
 	} else {
 		temp := table.FilePathBase + gen + "-SSTable.db"
@@ -68,7 +73,7 @@ func NewSSTable(conf *conf.Config, dataPath string, level string) (*SSTable, err
 		table.Toc.FilterPath = temp
 		table.Index = NewIndex(temp, 0, 0)
 		table.Toc.SummaryPath = temp
-		table.Toc.MetadataPath = table.FilePathBase + gen + "-metadata.txt"
+		table.Toc.MetadataPath = table.FilePathBase + gen + "-metadata.db"
 		table.TOCFilePath = table.FilePathBase + gen + "-TOC.yaml"
 
 	}
@@ -102,57 +107,30 @@ func (sstable *SSTable) WriteNewSSTable(data []memtable.Record, c *conf.Config)
 		// Adding a key to bloom filter
 		bf.Add(v.Key)
 
-		key := []byte(v.Key)
-		keySize := uint64(len(key))
-		value := v.Data
-		valueSize := uint64(len(value))
-		tombstone := v.Tombstone
-
-		timestamp := v.Timestamp
-		timestampBytes := make([]byte, 16)
-		binary.BigEndian.PutUint64(timestampBytes[:8], uint64(timestamp.Unix()))
-		binary.BigEndian.PutUint64(timestampBytes[8:], uint64(timestamp.Nanosecond()))
-
-		WrittenBytes := 0
-
 		keys[i] = v.Key
 		offsets[i] = file_offset
 
-		if err = binary.Write(writer, binary.BigEndian, v.Crc); err != nil {
-			return
-		}
-		if err = binary.Write(writer, binary.BigEndian, timestampBytes); err != nil {
-			return
-		}
-
-		if err = binary.Write(writer, binary.BigEndian, tombstone); err != nil {
-			return
-		}
-
-		if err = binary.Write(writer, binary.BigEndian, keySize); err != nil {
-			return
+		written, err := WriteDataRecord(writer, &DataElement{
+			CRC:       v.Crc,
+			Seq:       v.Seq,
+			Timestamp: v.Timestamp,
+			Tombstone: v.Tombstone,
+			Merge:     v.Merge,
+			Expires:   v.Expires,
+			KeySize:   uint64(len(v.Key)),
+			Key:       v.Key,
+			ValueSize: uint64(len(v.Data)),
+			Value:     v.Data,
+		})
+		if err != nil {
+			return err
 		}
+		file_offset += written
 
-		if err = binary.Write(writer, binary.BigEndian, valueSize); err != nil {
-			return
-		}
-
-		if WrittenBytes, err = writer.Write(key); err != nil {
-			return
-		}
-
-		file_offset += uint64(WrittenBytes)
-
-		if WrittenBytes, err = writer.Write(value); err != nil {
-			return
-		}
-
-		file_offset += uint64(WrittenBytes) + 37 // Broj zapisanih i 2 puta po 8 bajta za zapis velicine segmenata + Tombstone
 		if err = writer.Flush(); err != nil {
-			return
+			return err
 		}
 		writer.Reset(data_file)
-
 	}
 
 	sstable.Toc.DataSize = uint64(file_offset)
@@ -195,21 +173,21 @@ func (sstable *SSTable) WriteNewSSTable(data []memtable.Record, c *conf.Config)
 		bffile.Close()
 	}
 
-	sstable.CreateTOC()
 	sstable.CreateMerkle(c.MerkleChunkSize)
+	sstable.CreateTOC()
 	return nil
 }
 
-func (sstable *SSTable) Read(offset int64) ([]byte, bool) {
+func (sstable *SSTable) Read(offset int64) (DataElement, error) {
 	// Function used to read segment from DataSegment
 	data_file, err := os.OpenFile(sstable.Toc.DataPath, os.O_RDONLY, 0666)
 	if err != nil {
-		panic(err)
+		return DataElement{}, err
 	}
-	data_file.Seek(offset, 1)
-	temp, _ := ReadNextDataRecord(data_file)
-	// Mozda je bolje vratiti value nazad funkciji koja poziva ovu funkciju
-	return temp.Value, temp.Tombstone
+	defer data_file.Close()
+	data_file.Seek(offset, 0)
+	temp, _, err := ReadNextDataRecord(data_file, sstable.Toc.Version)
+	return temp, err
 }
 
 func (sstable *SSTable) CreateTOC() {
@@ -228,6 +206,7 @@ func GetNextGeneration(dataPath string, level string) (gen int, err error) {
 	if err != nil {
 		return
 	}
+	defer file.Close()
 
 	fileinfo, err := file.ReadDir(0)
 	if err != nil {
@@ -272,6 +251,22 @@ func GetTOC(toc_path string) *TOC {
 	return toc
 }
 
+func GetMovedTOC(toc_path string) (*TOC, error) {
+	// Loading a TOC of a table that could be copied from another data directory
+	// Paths of table files are resolved relative to the TOC file
+	toc, ok := tryLoad(toc_path)
+	if !ok {
+		return nil, errors.New("neuspesno ucitavanje TOC fajla " + toc_path)
+	}
+	dir := fp.Dir(toc_path)
+	toc.DataPath = fp.Join(dir, fp.Base(toc.DataPath))
+	toc.FilterPath = fp.Join(dir, fp.Base(toc.FilterPath))
+	toc.IndexPath = fp.Join(dir, fp.Base(toc.IndexPath))
+	toc.SummaryPath = fp.Join(dir, fp.Base(toc.SummaryPath))
+	toc.MetadataPath = fp.Join(dir, fp.Base(toc.MetadataPath))
+	return toc, nil
+}
+
 func (toc *TOC) Save(path string) {
 	// Function used for saving Table of Contents file
 	data, _ := yaml.Marshal(toc)
@@ -290,74 +285,139 @@ func tryLoad(path string) (*TOC, bool) {
 	return &c, err == nil
 }
 
-func ReadNextDataRecord(file *os.File) (DataElement, uint64) {
-	// Utility function used for reading next element in data segment
-	// Parameters:
-	//	- file : opened file that is already seeked on a corresponding postion
-	// Return:
-	//	- data record
-	reader := bufio.NewReader(file)
-	crc := make([]byte, 4)
+/*
+   +----------+----------+-----------------+----------------+--------------+---------------+-----------------+-...-+--...--+
+   | CRC (4B) | Seq (8B) | Timestamp (16B) | Tombstone (1B) | Expires (8B) | Key Size (8B) | Value Size (8B) | Key | Value |
+   +----------+----------+-----------------+----------------+--------------+---------------+-----------------+-...-+--...--+
+   CRC = hash.GetCrc over Key and Value
+   Seq = Sequence number of the operation from WAL, newer version of a key has greater Seq
+   Timestamp = Seconds and nanoseconds of the operation
+   Expires = Time in nanoseconds after which the value is treated as deleted, 0 if it never expires
+   Tabele prve verzije (Version 0 u TOC) nemaju Seq polje, a tabele verzije 2 nemaju Expires polje
+*/
+
+const (
+	TableVersion           = 3
+	RecordHeaderSize       = 53
+	legacyRecordHeaderSize = 37
+
+	seqVersion     = 2 // prva verzija sa Seq poljem
+	expiresVersion = 3 // prva verzija sa Expires poljem
+	expiresSize    = 8
+
+	tombstoneKind = 1
+	mergeKind     = 2
+)
+
+func recordHeaderSize(version int) int {
+	if version < seqVersion {
+		return legacyRecordHeaderSize
+	} else if version < expiresVersion {
+		return RecordHeaderSize - expiresSize
+	}
+	return RecordHeaderSize
+}
 
-	timestampBytes := make([]byte, 16)
+func WriteDataRecord(w io.Writer, rec *DataElement) (uint64, error) {
+	// Utility function used for writing one element of data segment
+	// Return:
+	//	- number of bytes written
+	header := make([]byte, RecordHeaderSize)
+	binary.BigEndian.PutUint32(header[0:], rec.CRC)
+	binary.BigEndian.PutUint64(header[4:], rec.Seq)
+	binary.BigEndian.PutUint64(header[12:], uint64(rec.Timestamp.Unix()))
+	binary.BigEndian.PutUint64(header[20:], uint64(rec.Timestamp.Nanosecond()))
+	if rec.Tombstone {
+		header[28] = tombstoneKind
+	} else if rec.Merge {
+		header[28] = mergeKind
+	}
+	if !rec.Expires.IsZero() {
+		binary.BigEndian.PutUint64(header[29:], uint64(rec.Expires.UnixNano()))
+	}
+	binary.BigEndian.PutUint64(header[37:], rec.KeySize)
+	binary.BigEndian.PutUint64(header[45:], rec.ValueSize)
 
-	_, err := reader.Read(crc)
-	crcv := binary.BigEndian.Uint32(crc)
-	if err != nil {
-		panic(err)
+	if _, err := w.Write(header); err != nil {
+		return 0, err
 	}
-	_, err = reader.Read(timestampBytes)
+	k, err := w.Write([]byte(rec.Key))
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-
-	timeSecond := int64(binary.BigEndian.Uint64(timestampBytes[:8]))
-	timeNanoseconds := int64(binary.BigEndian.Uint64(timestampBytes[8:]))
-	timestamp := time.Unix(timeSecond, timeNanoseconds)
-
-	tombstoneB, err := reader.ReadByte()
+	v, err := w.Write(rec.Value)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	tombstone := tombstoneB != 0
-
-	keySizeB := make([]byte, 8)
-	_, err = reader.Read(keySizeB)
+	return uint64(RecordHeaderSize + k + v), nil
+}
 
+func ReadNextDataRecord(file *os.File, version int) (DataElement, uint64, error) {
+	// Utility function used for reading next element in data segment
+	// Parameters:
+	//	- file : opened file that is already seeked on a corresponding postion
+	//	- version : version of the table from its TOC
+	// Return:
+	//	- data record, number of bytes read
+	//	- CorruptionError if the record CRC does not match its contents
+	//	  or its key and value sizes exceed the rest of the file
+	offset, err := file.Seek(0, io.SeekCurrent)
 	if err != nil {
-		panic(err)
+		return DataElement{}, 0, err
+	}
+	reader := bufio.NewReader(file)
 
+	headerSize := recordHeaderSize(version)
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return DataElement{}, 0, err
 	}
-	keySize := binary.BigEndian.Uint64(keySizeB)
 
-	valueSizeB := make([]byte, 8)
-	_, err = reader.Read(valueSizeB)
-	if err != nil {
-		panic(err)
+	rec := DataElement{CRC: binary.BigEndian.Uint32(header[:4])}
+	h := header[4:]
+	if version >= seqVersion {
+		rec.Seq = binary.BigEndian.Uint64(h[:8])
+		h = h[8:]
+	}
+	timeSecond := int64(binary.BigEndian.Uint64(h[0:8]))
+	timeNanoseconds := int64(binary.BigEndian.Uint64(h[8:16]))
+	rec.Timestamp = time.Unix(timeSecond, timeNanoseconds)
+	rec.Tombstone = h[16] == tombstoneKind
+	rec.Merge = h[16] == mergeKind
+	h = h[17:]
+	if version >= expiresVersion {
+		if expires := int64(binary.BigEndian.Uint64(h[:8])); expires != 0 {
+			rec.Expires = time.Unix(0, expires)
+		}
+		h = h[8:]
 	}
-	valueSize := binary.BigEndian.Uint64(valueSizeB)
-	key := make([]byte, keySize)
-	value := make([]byte, valueSize)
-	_, err = reader.Read(key)
+	rec.KeySize = binary.BigEndian.Uint64(h[0:8])
+	rec.ValueSize = binary.BigEndian.Uint64(h[8:16])
 
+	// velicine nisu pokrivene CRC-om, ostecena velicina ne sme izazvati alokaciju vecu od fajla
+	info, err := file.Stat()
 	if err != nil {
-		panic(err)
+		return DataElement{}, 0, err
+	}
+	remaining := uint64(max(info.Size()-offset-int64(headerSize), 0))
+	if rec.KeySize > remaining || rec.ValueSize > remaining || rec.KeySize+rec.ValueSize > remaining {
+		return DataElement{}, 0, &CorruptionError{Path: file.Name(), Offset: offset}
 	}
 
-	_, err = reader.Read(value)
-	if err != nil {
-		panic(err)
+	key := make([]byte, rec.KeySize)
+	rec.Value = make([]byte, rec.ValueSize)
+	if _, err = io.ReadFull(reader, key); err != nil {
+		return DataElement{}, 0, err
 	}
+	if _, err = io.ReadFull(reader, rec.Value); err != nil {
+		return DataElement{}, 0, err
+	}
+	rec.Key = string(key)
 
-	return DataElement{
-		CRC:       crcv,
-		Timestamp: timestamp,
-		Tombstone: tombstone,
-		KeySize:   keySize,
-		ValueSize: valueSize,
-		Key:       string(key),
-		Value:     value,
-	}, uint64(keySize + valueSize + 37)
+	if hash.GetCrc(rec.Key, rec.Value) != rec.CRC {
+		return DataElement{}, 0, &CorruptionError{Path: file.Name(), Offset: offset}
+	}
+	return rec, uint64(headerSize) + rec.KeySize + rec.ValueSize, nil
 }
 
 func GetSSTable(toc *TOC) *SSTable {
@@ -369,13 +429,18 @@ func GetSSTable(toc *TOC) *SSTable {
 }
 
 func (t *SSTable) QueryBloomFilter(key string) bool {
+	return t.LoadBloomFilter().Has(key)
+}
+
+// Ucitava ceo filter tabele, za proveru vise kljuceva bez ponovnog citanja fajla
+func (t *SSTable) LoadBloomFilter() *bloom.BloomFilter {
 	file, err := os.OpenFile(t.Toc.FilterPath, os.O_RDONLY, 0666)
 	if err != nil {
 		panic(err)
 	}
+	defer file.Close()
 	file.Seek(t.Toc.FilterOffset, 0)
-	bf := bloom.Deserialize(file)
-	return bf.Has(key)
+	return bloom.Deserialize(bufio.NewReader(file))
 }
 
 func (t *SSTable) QuerySummary(key string) (int64, int64) {
@@ -394,30 +459,130 @@ func (t *SSTable) QuerySummary(key string) (int64, int64) {
 }
 
 func (t *SSTable) CreateMerkle(chunkSize int) {
+	leafs, err := t.getChunkLeafs(chunkSize)
+	if err != nil {
+		panic(err)
+	}
+	mt := merkle.NewMerkleTree(leafs, chunkSize)
+	mt.Save(t.Toc.MetadataPath)
+	t.Toc.MerkleRoot = mt.String()
+}
+
+func (t *SSTable) GetMerkleRoot() ([]byte, error) {
+	// Function used for getting the root hash of table's Merkle tree
+	// Tables created before the root was kept in TOC have to load metadata
+	if t.Toc.MerkleRoot != "" {
+		return merkle.ToHex(t.Toc.MerkleRoot), nil
+	}
+	mt, err := merkle.Load(t.Toc.MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	return mt.RootHash(), nil
+}
+
+func (t *SSTable) VerifyMerkle(defaultChunkSize int) ([]Chunk, error) {
+	// Function used for checking data segment against stored Merkle tree
+	// Parameters:
+	//	- defaultChunkSize : used if chunk size is not stored with the tree
+	// Return:
+	//	- chunks of data segment whose hash differs from the stored one
+	stored, err := merkle.Load(t.Toc.MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := getChunkSize(stored, defaultChunkSize)
+	leafs, err := t.getChunkLeafs(chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := stored.Leaves()
+	corrupted := make([]Chunk, 0)
+	for i := 0; i < len(leafs) || i < len(expected); i++ {
+		if i >= len(leafs) || i >= len(expected) || !bytes.Equal(leafs[i].Hash(), expected[i]) {
+			corrupted = append(corrupted, t.GetChunk(i, chunkSize))
+		}
+	}
+	return corrupted, nil
+}
+
+func (t *SSTable) DiffMerkle(other *SSTable, defaultChunkSize int) ([]Chunk, error) {
+	// Function used for comparing this table with the same table from another data directory
+	// Return:
+	//	- chunks of data segment that differ between the two tables
+	chunks := make([]Chunk, 0)
+	if t.Toc.MerkleRoot != "" && t.Toc.MerkleRoot == other.Toc.MerkleRoot {
+		return chunks, nil
+	}
+
+	mt, err := merkle.Load(t.Toc.MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	other_mt, err := merkle.Load(other.Toc.MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := getChunkSize(mt, defaultChunkSize)
+	if getChunkSize(other_mt, defaultChunkSize) != chunkSize {
+		// delovi nisu poravnati, razlikuje se cela tabela
+		if other.Toc.DataSize > t.Toc.DataSize {
+			return other.GetAllChunks(chunkSize), nil
+		}
+		return t.GetAllChunks(chunkSize), nil
+	}
+
+	size := max(t.Toc.DataSize, other.Toc.DataSize)
+	for _, i := range merkle.Diff(mt, other_mt) {
+		chunk := Chunk{Index: i, Start: uint64(i * chunkSize), End: uint64((i + 1) * chunkSize)}
+		chunk.End = min(chunk.End, size)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (t *SSTable) GetAllChunks(chunkSize int) []Chunk {
+	chunks := make([]Chunk, 0)
+	for i := 0; uint64(i*chunkSize) < t.Toc.DataSize; i++ {
+		chunks = append(chunks, t.GetChunk(i, chunkSize))
+	}
+	return chunks
+}
+
+func getChunkSize(mt *merkle.MerkleRoot, defaultChunkSize int) int {
+	if mt.ChunkSize() > 0 {
+		return mt.ChunkSize()
+	}
+	return defaultChunkSize
+}
+
+func (t *SSTable) GetChunk(index int, chunkSize int) Chunk {
+	start := uint64(index * chunkSize)
+	end := start + uint64(chunkSize)
+	if end > t.Toc.DataSize {
+		end = t.Toc.DataSize
+	}
+	return Chunk{Index: index, Start: start, End: end}
+}
+
+func (t *SSTable) getChunkLeafs(chunkSize int) ([]*merkle.Node, error) {
+	file, err := os.Open(t.Toc.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
 	leafs := make([]*merkle.Node, 0)
-	max := t.Toc.DataSize
-	file, _ := os.Open(t.Toc.DataPath)
-	i := 0
-	for {
-		if i >= int(max) {
-			break
-		} else if i+100 > int(max) {
-			len := int(max) - i
-			t, err := util.ReadBytes(len, file)
-			leafs = append(leafs, merkle.GetLeaf(t))
-			if err != nil {
-				panic(err)
-			}
-			break
-		} else {
-			t, err := util.ReadBytes(chunkSize, file)
-			if err != nil {
-				panic(err)
-			}
-			leafs = append(leafs, merkle.GetLeaf(t))
-			i += chunkSize
+	for i := 0; uint64(i*chunkSize) < t.Toc.DataSize; i++ {
+		chunk := t.GetChunk(i, chunkSize)
+		data := make([]byte, chunk.End-chunk.Start)
+		n, err := io.ReadFull(file, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
 		}
+		leafs = append(leafs, merkle.GetLeaf(data[:n]))
 	}
-	mt := merkle.NewMerkleTree(leafs)
-	mt.Save(t.Toc.MetadataPath)
+	return leafs, nil
 }
@@ -1,38 +1,116 @@
 package tbucket
 
 import (
+	"context"
 	"errors"
 	conf "go-touch-grass/config"
+	"go-touch-grass/internal/util"
+	"io"
+	"math"
+	"sync"
 	"time"
 )
 
+var (
+	ErrTooManyRequests = errors.New("previse zahteva molimo sacekajte")
+	errCapacity        = errors.New("cena operacije je veca od kapaciteta token bucket-a")
+)
+
+// Tokeni se dopunjavaju ravnomerno, maxTokens tokena za resetDuration ms
 type TBucket struct {
-	ts            int64
-	resetDuration int64
-	maxTokens     int
-	tokens        int
+	mu        sync.Mutex
+	ts        int64   // vreme poslednjeg dopunjavanja u ms
+	rate      float64 // broj tokena koji se dodaje za jednu ms
+	maxTokens float64
+	tokens    float64
 }
 
 func New(config *conf.Config) *TBucket {
-	tokens := config.TBucketMaxTokens
+	return NewWithLimit(config.TBucketMaxTokens, config.TBucketResetDuration)
+}
+
+func NewWithLimit(maxTokens int, resetDuration int64) *TBucket {
+	tokens := float64(maxTokens)
 	return &TBucket{
-		ts:            0,
-		resetDuration: config.TBucketResetDuration,
-		maxTokens:     tokens,
-		tokens:        tokens,
+		ts:        time.Now().UnixMilli(),
+		rate:      tokens / float64(resetDuration),
+		maxTokens: tokens,
+		tokens:    tokens,
 	}
 }
 
-func (tb *TBucket) MakeQuery() error {
-	now := time.Now().UnixMilli()
-	if now-tb.ts > tb.resetDuration {
-		tb.ts = now
-		tb.tokens = tb.maxTokens - 1
-		return nil
+func (tb *TBucket) refill(now int64) {
+	if now > tb.ts {
+		tb.tokens = math.Min(tb.maxTokens, tb.tokens+float64(now-tb.ts)*tb.rate)
 	}
-	if tb.tokens != 0 {
-		tb.tokens -= 1
+	tb.ts = now
+}
+
+// Uzima cost tokena, ErrTooManyRequests ako ih trenutno nema dovoljno
+func (tb *TBucket) MakeQuery(cost int) error {
+	if float64(cost) > tb.maxTokens {
+		return errCapacity
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill(time.Now().UnixMilli())
+	if tb.tokens >= float64(cost) {
+		tb.tokens -= float64(cost)
 		return nil
 	}
-	return errors.New("previse zahteva molimo sacekajte")
+	return ErrTooManyRequests
+}
+
+// Ceka dok se ne dopuni cost tokena i uzima ih, ili dok ctx ne bude prekinut
+func (tb *TBucket) Wait(ctx context.Context, cost int) error {
+	if float64(cost) > tb.maxTokens {
+		return errCapacity
+	}
+	for {
+		tb.mu.Lock()
+		tb.refill(time.Now().UnixMilli())
+		missing := float64(cost) - tb.tokens
+		if missing <= 0 {
+			tb.tokens -= float64(cost)
+			tb.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration(math.Ceil(missing/tb.rate)) * time.Millisecond
+		tb.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Cuva vreme poslednjeg dopunjavanja i broj preostalih tokena
+func (tb *TBucket) Serialize(w io.Writer) int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	util.WriteInt64(tb.ts, w)
+	util.WriteUint(math.Float64bits(tb.tokens), w)
+	return 16
+}
+
+// Vraca sacuvano stanje, tokeni se dopunjuju i za vreme dok aplikacija nije radila
+func (tb *TBucket) Restore(r io.Reader) error {
+	ts, err := util.ReadInt64(r)
+	if err != nil {
+		return err
+	}
+	bits, err := util.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.ts = ts
+	tb.tokens = math.Min(tb.maxTokens, math.Float64frombits(bits))
+	tb.refill(time.Now().UnixMilli())
+	return nil
 }
@@ -0,0 +1,41 @@
+package tbucket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMakeQuery(t *testing.T) {
+	tb := NewWithLimit(4, 1000)
+	if err := tb.MakeQuery(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := tb.MakeQuery(2); err != ErrTooManyRequests {
+		t.Fatalf("ocekivano ErrTooManyRequests, dobijeno %v", err)
+	}
+	if err := tb.MakeQuery(5); err == nil || err == ErrTooManyRequests {
+		t.Fatalf("ocekivana greska kapaciteta, dobijeno %v", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	tb := NewWithLimit(10, 100)
+	if err := tb.MakeQuery(10); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := tb.Wait(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Wait se vratio posle %v, ocekivano bar 50ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tb.Wait(ctx, 10); err != context.DeadlineExceeded {
+		t.Errorf("ocekivano DeadlineExceeded, dobijeno %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"io"
+	fp "path/filepath"
+	"strings"
+	"time"
+)
+
+// Zapis iz loga sa mestom na kom se nalazi, koristi se za pregled loga
+// Neispravan zapis ima samo segment i poziciju
+type Entry struct {
+	Segment   string     `json:"segment"`
+	Offset    int64      `json:"offset"`
+	Seq       uint64     `json:"seq"`
+	Timestamp time.Time  `json:"timestamp"`
+	Type      string     `json:"type"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Family    uint32     `json:"family"`
+	Key       string     `json:"key"`
+	ValueSize int        `json:"value_size"`
+	CrcValid  bool       `json:"crc_valid"`
+}
+
+// Nulta vrednost polja znaci da se po njemu ne filtrira
+type Filter struct {
+	KeyPrefix string
+	From      time.Time
+	To        time.Time
+}
+
+func (f *Filter) matches(r *Record) bool {
+	if f.KeyPrefix != "" && !strings.HasPrefix(string(r.Key), f.KeyPrefix) {
+		return false
+	}
+	if !f.From.IsZero() && r.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && r.Timestamp.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// Prolazi kroz sve zapise loga u direktorijumu dir koji odgovaraju filteru
+// Izmene iz batch zapisa se prikazuju pojedinacno, log se ne menja pa se moze pregledati i kada oporavak ne uspe
+// Neispravan zapis se uvek prijavljuje, a citanje se nastavlja od sledeceg segmenta
+func Inspect(dir string, filter Filter, visit func(Entry) error) error {
+	paths, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	r := newReader(paths, Position{})
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if cerr, ok := err.(*CorruptionError); ok {
+			err = visit(Entry{Segment: fp.Base(cerr.Segment), Offset: cerr.Offset, Type: "?"})
+			if err != nil {
+				return err
+			}
+			index, _ := segmentIndex(cerr.Segment)
+			r = newReader(paths, Position{Segment: index + 1})
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		changes, err := record.Unbatch()
+		if err != nil {
+			// batch sa neispravnim sadrzajem se prikazuje kao jedan zapis
+			changes = []Record{*record}
+		}
+		for _, c := range changes {
+			if !filter.matches(&c) {
+				continue
+			}
+			var expires *time.Time
+			if !c.Expires.IsZero() {
+				expires = &c.Expires
+			}
+			err = visit(Entry{
+				Segment:   fp.Base(r.recordSeg.path),
+				Offset:    r.recordOffset,
+				Seq:       c.Seq,
+				Timestamp: c.Timestamp,
+				Type:      c.Type.String(),
+				Expires:   expires,
+				Family:    c.Family,
+				Key:       string(c.Key),
+				ValueSize: len(c.Value),
+				CrcValid:  true,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
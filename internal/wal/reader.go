@@ -0,0 +1,216 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+)
+
+// Pozicija zapisa u logu, indeks segmenta i pomeraj u segmentu
+type Position struct {
+	Segment int
+	Offset  int64
+}
+
+// Redom cita zapise iz segmenata loga i spaja zapise podeljene na vise fragmenata
+// U memoriji se drzi samo segment koji se trenutno cita
+type Reader struct {
+	from    Position
+	paths   []string
+	next    int // indeks sledeceg segmenta u paths
+	seg     *segment
+	segErr  error // greska na kraju trenutnog segmenta
+	pos     int   // sledeci fragment trenutnog segmenta
+	started bool  // procitan je pocetak bar jednog zapisa
+	err     error
+
+	pending      []byte // delovi zapisa koji jos nije procitan do kraja
+	pendingStart int64
+
+	// kraj poslednjeg celog zapisa, od te pozicije se nastavlja upis
+	endSeg    *segment
+	endOffset int64
+	lastSeq   uint64
+
+	// pocetak poslednjeg procitanog zapisa
+	recordSeg    *segment
+	recordOffset int64
+}
+
+func (p Position) before(other Position) bool {
+	return p.Segment < other.Segment || (p.Segment == other.Segment && p.Offset < other.Offset)
+}
+
+// Citanje loga od pozicije from, pozicija mora biti pocetak zapisa ili kraj segmenta
+func (w *WAL) NewReader(from Position) (*Reader, error) {
+	paths, err := listSegments(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(paths, from), nil
+}
+
+func newReader(paths []string, from Position) *Reader {
+	i := 0
+	for i < len(paths) {
+		if index, _ := segmentIndex(paths[i]); index >= from.Segment {
+			break
+		}
+		i++
+	}
+	return &Reader{from: from, paths: paths[i:]}
+}
+
+// Pozicija poslednjeg procitanog zapisa
+func (r *Reader) Position() Position {
+	index, _ := segmentIndex(r.recordSeg.path)
+	return Position{Segment: index, Offset: r.recordOffset}
+}
+
+// Pozicija iza poslednjeg celog zapisa
+func (r *Reader) End() Position {
+	if r.endSeg == nil {
+		return r.from
+	}
+	index, _ := segmentIndex(r.endSeg.path)
+	return Position{Segment: index, Offset: r.endOffset}
+}
+
+// Broj segmenata koji su do sada otvoreni
+func (r *Reader) Segments() int {
+	return r.next
+}
+
+// Sledeci zapis iz loga, io.EOF kada su procitani svi zapisi
+func (r *Reader) Next() (*Record, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	record, err := r.read()
+	if err != nil {
+		r.err = err
+	}
+	return record, err
+}
+
+func (r *Reader) read() (*Record, error) {
+	for {
+		if r.seg == nil || r.pos >= len(r.seg.fragments) {
+			if r.seg != nil && r.segErr != nil {
+				return nil, r.invalid(r.segErr.(*CorruptionError))
+			}
+			if r.seg != nil && !r.seg.full() && r.dataFollows() {
+				// fragment sa pogresnim tagom ili tipom usred loga
+				return nil, &CorruptionError{Segment: r.seg.path, Offset: r.seg.size}
+			}
+			if r.next >= len(r.paths) {
+				if r.pending != nil {
+					// poslednji zapis nije upisan do kraja
+					return nil, &CorruptionError{Segment: r.endSeg.path, Offset: r.pendingStart, Torn: true}
+				}
+				return nil, io.EOF
+			}
+			if err := r.load(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		f := r.seg.fragments[r.pos]
+		r.pos++
+		switch f.kind {
+		case fullFragment, firstFragment:
+			if r.pending != nil {
+				// prethodni zapis nije zavrsen
+				return nil, &CorruptionError{Segment: r.seg.path, Offset: f.offset}
+			}
+			r.started = true
+			if f.kind == fullFragment {
+				r.recordSeg = r.seg
+				return r.decode(f.data, f.offset, f.end)
+			}
+			r.pending = append([]byte{}, f.data...)
+			r.pendingStart = f.offset
+		case middleFragment, lastFragment:
+			if r.pending == nil {
+				if !r.started {
+					// kraj zapisa ciji je pocetak u segmentu koji je obrisan
+					continue
+				}
+				return nil, &CorruptionError{Segment: r.seg.path, Offset: f.offset}
+			}
+			r.pending = append(r.pending, f.data...)
+			if f.kind == lastFragment {
+				data := r.pending
+				r.pending = nil
+				r.recordSeg = r.endSeg
+				return r.decode(data, r.pendingStart, f.end)
+			}
+		}
+	}
+}
+
+func (r *Reader) load() error {
+	seg, err := readSegment(r.paths[r.next])
+	if _, ok := err.(*CorruptionError); err != nil && !ok {
+		return err
+	}
+	r.next++
+	r.seg, r.segErr, r.pos = seg, err, 0
+	if r.pending == nil {
+		r.endSeg = seg
+		r.endOffset = seg.dataStart()
+	}
+	if index, _ := segmentIndex(seg.path); r.next == 1 && index == r.from.Segment && r.from.Offset > 0 {
+		// zapisi pre pocetne pozicije se preskacu
+		for r.pos < len(seg.fragments) && seg.fragments[r.pos].offset < r.from.Offset {
+			r.pos++
+		}
+		r.endOffset = r.from.Offset
+		r.started = true
+	}
+	if seg.startSeq > r.lastSeq+1 {
+		r.lastSeq = seg.startSeq - 1
+	}
+	return nil
+}
+
+func (r *Reader) decode(data []byte, start int64, end int64) (*Record, error) {
+	r.recordOffset = start
+	record, _, err := readRecord(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		// zapis pocinje u segmentu u kom je zavrsen prethodni
+		return nil, &CorruptionError{Segment: r.recordSeg.path, Offset: start}
+	}
+
+	r.endSeg, r.endOffset = r.seg, end
+	count, err := record.seqCount()
+	if err != nil {
+		return nil, err
+	}
+	if last := record.Seq + count - 1; record.Seq > 0 && last > r.lastSeq {
+		r.lastSeq = last
+	}
+	return &record, nil
+}
+
+// Neispravan fragment je nepotpun upis samo ako iza njega u logu nema ispravnih zapisa
+func (r *Reader) invalid(cerr *CorruptionError) error {
+	if !cerr.Torn || r.dataFollows() {
+		return &CorruptionError{Segment: cerr.Segment, Offset: cerr.Offset}
+	}
+	if r.pending != nil {
+		return &CorruptionError{Segment: r.endSeg.path, Offset: r.pendingStart, Torn: true}
+	}
+	return &CorruptionError{Segment: cerr.Segment, Offset: cerr.Offset, Torn: true}
+}
+
+// Da li neki od preostalih segmenata sadrzi zapise
+func (r *Reader) dataFollows() bool {
+	for _, path := range r.paths[r.next:] {
+		seg, _ := readSegment(path)
+		if len(seg.fragments) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -1,15 +1,365 @@
 package wal
 
-import "time"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+/*
+   +----------+----------+-----------------+-----------+---------------+-----------------+-...-+--...--+
+   | CRC (4B) | Seq (8B) | Timestamp (8B)  | Type (1B) | Key Size (8B) | Value Size (8B) | Key | Value |
+   +----------+----------+-----------------+-----------+---------------+-----------------+-...-+--...--+
+   CRC = 32bit hash computed over the rest of the record using CRC
+   Seq = Sequence number of the operation, increases with every mutation
+   Timestamp = Timestamp of the operation in nanoseconds
+   Type = Put, Delete, Flush marker, Batch or Merge operand, the highest bit marks a value prefixed with expiry time,
+          the next bit a value prefixed with column family id
+   Key Size = Length of the Key data
+   Value Size = Length of the Value data
+   Key = Key data
+   Value = Value data, for a Batch encoded records without CRC, Seq and Timestamp,
+           for a Flush marker the last sequence persisted in SSTables
+   Family = Column family id (4B, before the expiry), records without it belong to the default family
+   Expiry = Time in nanoseconds after which the value is no longer visible (8B, before the value)
+*/
+
+const (
+	CrcSize       = 4
+	SeqSize       = 8
+	TimestampSize = 8
+	TypeSize      = 1
+	KeySizeSize   = 8
+	ValueSizeSize = 8
+
+	CrcStart         = 0
+	SeqStart         = CrcStart + CrcSize
+	TimestampStart   = SeqStart + SeqSize
+	TypeStart        = TimestampStart + TimestampSize
+	KeySizeStart     = TypeStart + TypeSize
+	ValueSizeStart   = KeySizeStart + KeySizeSize
+	KeyStart         = ValueSizeStart + ValueSizeSize
+	RecordHeaderSize = KeyStart
+
+	batchHeaderSize = TypeSize + KeySizeSize + ValueSizeSize
+
+	expiresFlag = 0x80
+	expiresSize = 8
+	familyFlag  = 0x40
+	familySize  = 4
+)
+
+type RecordType byte
+
+const (
+	PutRecord RecordType = iota + 1
+	DeleteRecord
+	FlushRecord
+	BatchRecord
+	MergeRecord
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case PutRecord:
+		return "put"
+	case DeleteRecord:
+		return "delete"
+	case FlushRecord:
+		return "flush"
+	case BatchRecord:
+		return "batch"
+	case MergeRecord:
+		return "merge"
+	}
+	return "nepoznat"
+}
 
 type Record struct {
-	FlushFlag bool
+	Seq       uint64
+	Type      RecordType
 	Timestamp time.Time
-	Tombstone bool
+	Expires   time.Time // nulta vrednost znaci da vrednost ne istice
+	Family    uint32    // id familije kolona, 0 je podrazumevana familija
 	Key       []byte
 	Value     []byte
 }
 
-func NewRecord(timestamp time.Time, tombstone bool, key []byte, value []byte) *Record {
-	return &Record{Timestamp: timestamp, Tombstone: tombstone, Key: key, Value: value}
+// Seq se dodeljuje prilikom upisa u WAL
+func NewRecord(recordType RecordType, key []byte, value []byte) *Record {
+	return &Record{Type: recordType, Timestamp: time.Now(), Key: key, Value: value}
+}
+
+// Upis koji prestaje da vazi u trenutku expires
+func NewExpiringRecord(key []byte, value []byte, expires time.Time) *Record {
+	record := NewRecord(PutRecord, key, value)
+	record.Expires = expires
+	return record
+}
+
+// Tip i vrednost kako se upisuju u log, familija kolona i vreme isteka se cuvaju ispred vrednosti
+func packValue(r *Record) (byte, []byte) {
+	t := byte(r.Type)
+	var prefix []byte
+	if r.Family != 0 {
+		t |= familyFlag
+		prefix = binary.BigEndian.AppendUint32(prefix, r.Family)
+	}
+	if !r.Expires.IsZero() {
+		t |= expiresFlag
+		prefix = binary.BigEndian.AppendUint64(prefix, uint64(r.Expires.UnixNano()))
+	}
+	if prefix == nil {
+		return t, r.Value
+	}
+	return t, append(prefix, r.Value...)
+}
+
+// Popunjava tip, familiju, vreme isteka i vrednost zapisa
+func unpackValue(t byte, value []byte, r *Record) error {
+	r.Type = RecordType(t &^ (familyFlag | expiresFlag))
+	if t&familyFlag != 0 {
+		if len(value) < familySize {
+			return errors.New("neispravna familija kolona zapisa")
+		}
+		r.Family = binary.BigEndian.Uint32(value)
+		value = value[familySize:]
+	}
+	if t&expiresFlag != 0 {
+		if len(value) < expiresSize {
+			return errors.New("neispravno vreme isteka zapisa")
+		}
+		r.Expires = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		value = value[expiresSize:]
+	}
+	r.Value = value
+	return nil
+}
+
+// Oznaka da su sve izmene familije do sekvence seq (ukljucujuci) upisane u SSTabele
+func NewFlushRecord(family uint32, seq uint64) *Record {
+	value := make([]byte, SeqSize)
+	binary.BigEndian.PutUint64(value, seq)
+	record := NewRecord(FlushRecord, nil, value)
+	record.Family = family
+	return record
+}
+
+// Poslednja sekvenca upisana u SSTabele, oznake starijih verzija je nemaju
+func (r *Record) FlushedSeq() (uint64, bool) {
+	if r.Type != FlushRecord || len(r.Value) != SeqSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(r.Value), true
+}
+
+// Broj sekvenci koje zapis zauzima (batch zauzima po jednu za svaku izmenu)
+func (r *Record) seqCount() (uint64, error) {
+	if r.Type != BatchRecord {
+		return 1, nil
+	}
+	records, err := r.Unbatch()
+	return uint64(len(records)), err
+}
+
+func encodeRecord(r *Record) []byte {
+	t, value := packValue(r)
+	size := RecordHeaderSize + len(r.Key) + len(value)
+	data := make([]byte, size)
+	binary.BigEndian.PutUint64(data[SeqStart:], r.Seq)
+	binary.BigEndian.PutUint64(data[TimestampStart:], uint64(r.Timestamp.UnixNano()))
+	data[TypeStart] = t
+	binary.BigEndian.PutUint64(data[KeySizeStart:], uint64(len(r.Key)))
+	binary.BigEndian.PutUint64(data[ValueSizeStart:], uint64(len(value)))
+	copy(data[KeyStart:], r.Key)
+	copy(data[KeyStart+len(r.Key):], value)
+	binary.BigEndian.PutUint32(data[CrcStart:], CRC32(data[SeqStart:]))
+	return data
+}
+
+// Cita jedan zapis, remaining je broj bajtova do kraja segmenta
+func readRecord(r io.Reader, remaining int64) (Record, int64, error) {
+	return checkRecordSize(parseRecord, r, remaining)
+}
+
+// Velicina iz zaglavlja koja prelazi kraj segmenta znaci nepotpun upis samo ako iza zapisa nema ispravnog,
+// inace je polje velicine osteceno usred loga
+func checkRecordSize(parse func(io.Reader, int64) (Record, int64, error), r io.Reader, remaining int64) (Record, int64, error) {
+	record, n, err := parse(r, remaining)
+	if err != errRecordSize {
+		return record, n, err
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return Record{}, 0, err
+	}
+	for i := range rest {
+		if _, _, err := parse(bytes.NewReader(rest[i:]), int64(len(rest)-i)); err == nil {
+			return Record{}, 0, errCorrupted
+		}
+	}
+	return Record{}, 0, errTornRecord
+}
+
+func parseRecord(r io.Reader, remaining int64) (Record, int64, error) {
+	header := make([]byte, RecordHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Record{}, 0, errTornRecord
+	} else if err != nil {
+		return Record{}, 0, err
+	}
+
+	crc := binary.BigEndian.Uint32(header[CrcStart:])
+	keySize := binary.BigEndian.Uint64(header[KeySizeStart:])
+	valueSize := binary.BigEndian.Uint64(header[ValueSizeStart:])
+
+	size := int64(RecordHeaderSize) + int64(keySize) + int64(valueSize)
+	if keySize > uint64(remaining) || valueSize > uint64(remaining) || size > remaining {
+		return Record{}, 0, errRecordSize
+	}
+
+	data := make([]byte, keySize+valueSize)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return Record{}, 0, errTornRecord
+	}
+	h := crc32.NewIEEE()
+	h.Write(header[SeqStart:])
+	h.Write(data)
+	if h.Sum32() != crc {
+		return Record{}, size, errInvalidCrc
+	}
+
+	record := Record{
+		Seq:       binary.BigEndian.Uint64(header[SeqStart:]),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[TimestampStart:]))),
+		Key:       data[:keySize],
+	}
+	if err = unpackValue(header[TypeStart], data[keySize:], &record); err != nil {
+		return Record{}, size, errInvalidCrc
+	}
+	return record, size, nil
+}
+
+/*
+   Zapis iz segmenata bez zaglavlja (prva verzija WAL-a)
+   +----------+----------------+----------------+-----------------+---------------+-----------------+-...-+--...--+
+   | CRC (4B) | Timestamp (8B) | Tombstone (1B) | Flush Flag (1B) | Key Size (8B) | Value Size (8B) | Key | Value |
+   +----------+----------------+----------------+-----------------+---------------+-----------------+-...-+--...--+
+   CRC se racuna samo nad kljucem i vrednoscu, Timestamp je u sekundama
+*/
+
+const legacyHeaderSize = 30
+
+func readLegacyRecord(r io.Reader, remaining int64) (Record, int64, error) {
+	return checkRecordSize(parseLegacyRecord, r, remaining)
+}
+
+func parseLegacyRecord(r io.Reader, remaining int64) (Record, int64, error) {
+	header := make([]byte, legacyHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Record{}, 0, errTornRecord
+	} else if err != nil {
+		return Record{}, 0, err
+	}
+
+	crc := binary.BigEndian.Uint32(header[0:])
+	timestamp := int64(binary.BigEndian.Uint64(header[4:]))
+	keySize := binary.BigEndian.Uint64(header[14:])
+	valueSize := binary.BigEndian.Uint64(header[22:])
+
+	size := int64(legacyHeaderSize) + int64(keySize) + int64(valueSize)
+	if keySize > uint64(remaining) || valueSize > uint64(remaining) || size > remaining {
+		return Record{}, 0, errRecordSize
+	}
+
+	data := make([]byte, keySize+valueSize)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return Record{}, 0, errTornRecord
+	}
+	if CRC32(data) != crc {
+		return Record{}, size, errInvalidCrc
+	}
+
+	recordType := PutRecord
+	if header[13] == 1 {
+		recordType = FlushRecord
+	} else if header[12] == 1 {
+		recordType = DeleteRecord
+	}
+	return Record{
+		Type:      recordType,
+		Timestamp: time.Unix(timestamp, 0),
+		Key:       data[:keySize],
+		Value:     data[keySize:],
+	}, size, nil
+}
+
+// Pakovanje vise izmena u jedan zapis koji se upisuje atomicno
+func NewBatch(records []*Record) *Record {
+	buf := new(bytes.Buffer)
+	for _, r := range records {
+		t, value := packValue(r)
+		header := make([]byte, batchHeaderSize)
+		header[0] = t
+		binary.BigEndian.PutUint64(header[TypeSize:], uint64(len(r.Key)))
+		binary.BigEndian.PutUint64(header[TypeSize+KeySizeSize:], uint64(len(value)))
+		buf.Write(header)
+		buf.Write(r.Key)
+		buf.Write(value)
+	}
+	return NewRecord(BatchRecord, nil, buf.Bytes())
+}
+
+// Raspakivanje batch zapisa, izmene dobijaju uzastopne sekvence
+func (r *Record) Unbatch() ([]Record, error) {
+	if r.Type != BatchRecord {
+		return []Record{*r}, nil
+	}
+
+	records := make([]Record, 0)
+	data := r.Value
+	for len(data) > 0 {
+		if len(data) < batchHeaderSize {
+			return nil, errors.New("neispravan batch zapis")
+		}
+		t := data[0]
+		keySize := binary.BigEndian.Uint64(data[TypeSize:])
+		valueSize := binary.BigEndian.Uint64(data[TypeSize+KeySizeSize:])
+		data = data[batchHeaderSize:]
+		if keySize > uint64(len(data)) || valueSize > uint64(len(data))-keySize {
+			return nil, errors.New("neispravan batch zapis")
+		}
+		record := Record{
+			Seq:       r.Seq + uint64(len(records)),
+			Timestamp: r.Timestamp,
+			Key:       data[:keySize],
+		}
+		if err := unpackValue(t, data[keySize:keySize+valueSize], &record); err != nil {
+			return nil, err
+		}
+		if record.Type != PutRecord && record.Type != DeleteRecord && record.Type != MergeRecord {
+			return nil, errors.New("batch moze sadrzati samo upise, brisanja i izmene za spajanje")
+		}
+		records = append(records, record)
+		data = data[keySize+valueSize:]
+	}
+	return records, nil
+}
+
+// Greska koja se vraca kada je zapis u logu ostecen
+// Torn je true ako se neispravan zapis proteze do kraja segmenta
+type CorruptionError struct {
+	Segment string
+	Offset  int64
+	Torn    bool
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("ostecen zapis u WAL segmentu %s na poziciji %d", e.Segment, e.Offset)
 }
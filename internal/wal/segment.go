@@ -0,0 +1,310 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+   +------------+--------------+------------------+----------+-...-+----------+-----------+
+   | Magic (4B) | Version (1B) | Start Seq (8B)   | Fragment | ... | Fragment | 0 0 0 ... |
+   +------------+--------------+------------------+----------+-...-+----------+-----------+
+   Svaki segment ima tacno WalSegmentSize bajtova, prostor iza poslednjeg fragmenta je prazan
+   Start Seq je sekvenca koja je bila sledeca kada je segment otvoren
+
+   Fragment:
+   +----------+----------+-------------+-----------+---------+
+   | CRC (4B) | Tag (4B) | Length (4B) | Type (1B) | Payload |
+   +----------+----------+-------------+-----------+---------+
+   CRC = racuna se nad ostatkom fragmenta
+   Tag = donja 32 bita Start Seq segmenta, fragmenti ostali od prethodne upotrebe fajla imaju drugi tag
+   Type = ceo zapis, prvi, srednji ili poslednji deo zapisa, 0 oznacava kraj segmenta
+   Payload = deo kodiranog zapisa (Record)
+
+   Segmenti verzije 2 nemaju fragmente, zapisi slede jedan za drugim i segment nema fiksnu velicinu
+   Segmenti bez zaglavlja su iz prve verzije WAL-a, obe starije verzije se mogu samo citati
+*/
+
+var segmentMagic = []byte("GTGW")
+
+const (
+	Version           = 3
+	SegmentHeaderSize = 13
+
+	FragmentHeaderSize = 13
+	MinSegmentSize     = SegmentHeaderSize + FragmentHeaderSize + 1
+
+	// broj praznih segmenata koji se cuvaju za ponovnu upotrebu
+	recycleLimit = 4
+)
+
+const (
+	fullFragment byte = iota + 1
+	firstFragment
+	middleFragment
+	lastFragment
+)
+
+// Deo zapisa procitan iz segmenta
+type fragment struct {
+	kind   byte
+	offset int64
+	end    int64
+	data   []byte
+}
+
+type segment struct {
+	path      string
+	fileSize  int64
+	version   byte
+	startSeq  uint64
+	fragments []fragment
+	size      int64 // kraj ispravnog dela segmenta
+}
+
+func segmentIndex(path string) (int, bool) {
+	name := fp.Base(path)
+	if !strings.HasPrefix(name, "wal_") {
+		return 0, false
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(name, "wal_"))
+	return index, err == nil
+}
+
+// Putanje segmenata sortirane po indeksu
+func listSegments(dir string) ([]string, error) {
+	files, err := fp.Glob(fp.Join(dir, "wal_*"))
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		if _, ok := segmentIndex(file); ok {
+			paths = append(paths, file)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		index1, _ := segmentIndex(paths[i])
+		index2, _ := segmentIndex(paths[j])
+		return index1 < index2
+	})
+	return paths, nil
+}
+
+func fragmentTag(startSeq uint64) uint32 {
+	return uint32(startSeq)
+}
+
+func encodeFragment(tag uint32, kind byte, payload []byte) []byte {
+	data := make([]byte, FragmentHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[4:], tag)
+	binary.BigEndian.PutUint32(data[8:], uint32(len(payload)))
+	data[12] = kind
+	copy(data[FragmentHeaderSize:], payload)
+	binary.BigEndian.PutUint32(data, CRC32(data[4:]))
+	return data
+}
+
+// Pozicija prvog zapisa u segmentu
+func (seg *segment) dataStart() int64 {
+	if seg.version == 0 {
+		return 0
+	}
+	return SegmentHeaderSize
+}
+
+// Segment je popunjen ako iza poslednjeg fragmenta ne staje novi
+// Upis se nastavlja samo u poslednjem segmentu, pa raniji segmenti moraju biti popunjeni
+func (seg *segment) full() bool {
+	return seg.version != Version || seg.fileSize-seg.size <= FragmentHeaderSize
+}
+
+// Otvara segment fiksne velicine, koristi prazan segment ako postoji
+func (w *WAL) openSegment(index int) (*os.File, error) {
+	path := w.segmentPath(index)
+	flag := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	free, _ := fp.Glob(fp.Join(w.dir, "free_*"))
+	if len(free) > 0 {
+		if err := os.Rename(free[0], path); err != nil {
+			return nil, err
+		}
+		// stari sadrzaj se ne brise, njegovi fragmenti imaju drugi tag
+		flag = os.O_RDWR
+	}
+	file, err := os.OpenFile(path, flag, 0777)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Truncate(w.sgmtsize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Segment koji vise nije potreban cuva se za ponovnu upotrebu
+func (w *WAL) recycle(path string) error {
+	free, _ := fp.Glob(fp.Join(w.dir, "free_*"))
+	if len(free) >= recycleLimit {
+		return os.Remove(path)
+	}
+	for i := 0; ; i++ {
+		name := fp.Join(w.dir, fmt.Sprintf("free_%03d", i))
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return os.Rename(path, name)
+		}
+	}
+}
+
+// Pocetna sekvenca iz zaglavlja segmenta, segmenti bez zaglavlja su stariji od svih sekvenci
+func readStartSeq(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	header := make([]byte, SegmentHeaderSize)
+	if _, err = io.ReadFull(file, header); err != nil || !bytes.HasPrefix(header, segmentMagic) {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(header[len(segmentMagic)+1:]), nil
+}
+
+// Cita delove zapisa iz segmenta do prvog neispravnog
+func readSegment(path string) (*segment, error) {
+	seg := &segment{path: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return seg, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return seg, err
+	}
+	seg.fileSize = info.Size()
+
+	header := make([]byte, min(SegmentHeaderSize, info.Size()))
+	if _, err = io.ReadFull(file, header); err != nil {
+		return seg, err
+	}
+	if len(header) == SegmentHeaderSize && bytes.Equal(header, make([]byte, SegmentHeaderSize)) {
+		// segment je zauzet ali zaglavlje nije upisano
+		return seg, nil
+	}
+	if !bytes.HasPrefix(segmentMagic, header[:min(len(header), len(segmentMagic))]) {
+		// segment bez zaglavlja, cita se od pocetka
+		file.Seek(0, io.SeekStart)
+		return seg, seg.readRecords(file, info.Size(), readLegacyRecord)
+	}
+
+	if len(header) < SegmentHeaderSize {
+		return seg, &CorruptionError{Segment: path, Offset: 0, Torn: true}
+	}
+	seg.version = header[len(segmentMagic)]
+	seg.startSeq = binary.BigEndian.Uint64(header[len(segmentMagic)+1:])
+	seg.size = SegmentHeaderSize
+	switch seg.version {
+	case 2:
+		return seg, seg.readRecords(file, info.Size(), readRecord)
+	case Version:
+		return seg, seg.readFragments(file, info.Size())
+	}
+	return seg, fmt.Errorf("nepodrzana verzija WAL segmenta %s (%d)", path, seg.version)
+}
+
+// Segmenti starijih verzija, svaki zapis se cita kao ceo fragment
+func (seg *segment) readRecords(file *os.File, size int64, readNext func(io.Reader, int64) (Record, int64, error)) error {
+	r := bufio.NewReader(file)
+	for seg.size < size {
+		record, n, err := readNext(r, size-seg.size)
+		if err == errTornRecord {
+			return &CorruptionError{Segment: seg.path, Offset: seg.size, Torn: true}
+		} else if err == errCorrupted {
+			return &CorruptionError{Segment: seg.path, Offset: seg.size}
+		} else if err == errInvalidCrc {
+			torn := seg.size+n == size
+			return &CorruptionError{Segment: seg.path, Offset: seg.size, Torn: torn}
+		} else if err != nil {
+			return err
+		}
+		seg.fragments = append(seg.fragments, fragment{
+			kind:   fullFragment,
+			offset: seg.size,
+			end:    seg.size + n,
+			data:   encodeRecord(&record),
+		})
+		seg.size += n
+	}
+	return nil
+}
+
+// Fragmenti se citaju do praznog prostora ili fragmenta sa drugim tagom
+func (seg *segment) readFragments(file *os.File, size int64) error {
+	data := make([]byte, size-SegmentHeaderSize)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return err
+	}
+	tag := fragmentTag(seg.startSeq)
+
+	for seg.size+FragmentHeaderSize <= size {
+		header := data[seg.size-SegmentHeaderSize:]
+		kind := header[12]
+		if kind == 0 || binary.BigEndian.Uint32(header[4:]) != tag {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[8:]))
+		end := seg.size + FragmentHeaderSize + length
+		if kind > lastFragment || end > size {
+			// neispravna duzina ili tip je nepotpun upis samo ako iza fragmenta nema ispravnog
+			torn := !fragmentFollows(data[seg.size-SegmentHeaderSize+FragmentHeaderSize:], tag)
+			return &CorruptionError{Segment: seg.path, Offset: seg.size, Torn: torn}
+		}
+		raw := header[:FragmentHeaderSize+length]
+		if CRC32(raw[4:]) != binary.BigEndian.Uint32(raw) {
+			// ako iza neispravnog fragmenta sledi ispravan, upis nije prekinut vec je zapis ostecen
+			torn := !validFragment(data[end-SegmentHeaderSize:], tag)
+			return &CorruptionError{Segment: seg.path, Offset: seg.size, Torn: torn}
+		}
+		seg.fragments = append(seg.fragments, fragment{
+			kind:   kind,
+			offset: seg.size,
+			end:    end,
+			data:   raw[FragmentHeaderSize:],
+		})
+		seg.size = end
+	}
+	return nil
+}
+
+// Da li neki od narednih bajtova segmenta pocinje ispravan fragment
+func fragmentFollows(data []byte, tag uint32) bool {
+	for i := range data {
+		if validFragment(data[i:], tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func validFragment(data []byte, tag uint32) bool {
+	if len(data) < FragmentHeaderSize {
+		return false
+	}
+	kind := data[12]
+	length := int(binary.BigEndian.Uint32(data[8:]))
+	if kind == 0 || kind > lastFragment || binary.BigEndian.Uint32(data[4:]) != tag || FragmentHeaderSize+length > len(data) {
+		return false
+	}
+	return CRC32(data[4:FragmentHeaderSize+length]) == binary.BigEndian.Uint32(data)
+}
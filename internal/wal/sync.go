@@ -0,0 +1,95 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Nacin na koji se upisi u log trajno cuvaju na disku
+const (
+	SyncNone     = "none"     // o upisu na disk odlucuje operativni sistem
+	SyncAlways   = "always"   // fsync posle svakog upisa
+	SyncGroup    = "group"    // jedan fsync za sve upise koji su istovremeno cekali
+	SyncPeriodic = "periodic" // fsync na svakih WalSyncInterval milisekundi
+)
+
+// Grupni commit, prvi pisac koji ceka radi fsync za sve ostale
+type groupCommit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	syncing bool
+	synced  uint64 // broj bajtova loga koji su sigurno na disku
+}
+
+func newGroupCommit() *groupCommit {
+	g := &groupCommit{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *groupCommit) markSynced(written uint64) {
+	g.mu.Lock()
+	if written > g.synced {
+		g.synced = written
+	}
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+// Ceka da bajtovi loga do pozicije pos budu na disku, ostale politike ne cekaju
+// jer je upis vec zavrsen u skladu sa njima
+func (w *WAL) WaitSynced(pos uint64) error {
+	if w.policy != SyncGroup {
+		return nil
+	}
+	g := w.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.synced < pos {
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+		g.syncing = true
+		g.mu.Unlock()
+
+		w.mu.Lock()
+		file, target := w.file, w.written
+		w.mu.Unlock()
+		err := file.Sync()
+		if errors.Is(err, os.ErrClosed) {
+			// segment je zatvoren pri rotaciji, a pre zatvaranja je sinhronizovan
+			err = nil
+		}
+
+		g.mu.Lock()
+		g.syncing = false
+		if err == nil && target > g.synced {
+			g.synced = target
+		}
+		g.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) syncPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			file := w.file
+			w.mu.Unlock()
+			file.Sync()
+		case <-w.stop:
+			return
+		}
+	}
+}
@@ -1,435 +1,361 @@
 package wal
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"go-touch-grass/config"
 	"hash/crc32"
 	"io"
 	"os"
 	fp "path/filepath"
-	"sort"
+	"sync"
 	"time"
 
 	"golang.org/x/exp/slices"
 )
 
-/*
-   +---------------+-----------------+---------------+---------------+-----------------+-...-+--...--+
-   |    CRC (4B)   | Timestamp (8B) | Tombstone(1B) | Key Size (8B) | Value Size (8B) | Key | Value |
-   +---------------+-----------------+---------------+---------------+-----------------+-...-+--...--+
-   CRC = 32bit hash computed over the payload using CRC
-   Key Size = Length of the Key data
-   Tombstone = If this record was deleted and has a value
-   Value Size = Length of the Value data
-   Key = Key data
-   Value = Value data
-   Timestamp = Timestamp of the operation in seconds
-*/
-
-const (
-	CrcSize       = 4
-	TimestampSize = 8
-	TombstoneSize = 1
-	KeySizeSize   = 8
-	ValueSizeSize = 8
-
-	CrcStart       = 0
-	TimestampStart = CrcStart + CrcSize
-	TombstoneStart = TimestampStart + TimestampSize
-	KeySizeStart   = TombstoneStart + TombstoneSize
-	ValueSizeStart = KeySizeStart + KeySizeSize
-	KeyStart       = ValueSizeStart + ValueSizeSize
+var (
+	errTornRecord = errors.New("nepotpun zapis")
+	errInvalidCrc = errors.New("neispravan CRC")
+	errCorrupted  = errors.New("ostecen zapis usred segmenta")
+	errRecordSize = errors.New("velicina zapisa prelazi kraj segmenta")
 )
 
 func CRC32(data []byte) uint32 {
 	return crc32.ChecksumIEEE(data)
 }
 
-func findHighestIndex(files []string) int {
-	highestIndex := -1 // Initialize to -1 so that any positive index is considered
-	for _, file := range files {
-		var index int
-		_, err := fmt.Sscanf(fp.Base(file), "wal_%03d", &index)
-		if err == nil && index > highestIndex {
-			highestIndex = index
-		}
-	}
-
-	if highestIndex == -1 {
-		highestIndex = 0
-	}
-
-	return highestIndex
-}
-
 type WAL struct {
+	mu       sync.Mutex
 	dir      string
 	index    int
-	lwm      int
 	sgmtsize int64
 	file     *os.File
+	offset   int64    // pozicija sledeceg fragmenta u trenutnom segmentu
+	limit    int64    // velicina trenutnog segmenta
+	tag      uint32   // tag fragmenata trenutnog segmenta
+	seq      uint64   // poslednja dodeljena sekvenca
+	flushed  Position // pozicija od koje pocinje oporavak
+	written  uint64   // ukupan broj bajtova upisanih od pokretanja
+	policy   string
+	group    *groupCommit
+	stop     chan struct{}
+	closed   sync.Once
 }
 
-func New(logPath string, config *config.Config) *WAL {
+func New(logPath string, config *config.Config) (*WAL, error) {
+	if config.WalSegmentSize < MinSegmentSize {
+		return nil, fmt.Errorf("velicina WAL segmenta mora biti bar %d bajtova", MinSegmentSize)
+	}
 	os.MkdirAll(logPath, 0777)
 
-	files, _ := fp.Glob(fp.Join(logPath, "wal_*"))
-	highestIndex := findHighestIndex(files)
-
-	// Create a new WAL with the next index
-	filename := fp.Join(logPath, fmt.Sprintf("wal_%03d", highestIndex))
-	file, _ := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0777)
-
-	return &WAL{
+	w := &WAL{
 		dir:      logPath,
-		index:    highestIndex,
-		lwm:      config.WalLowWaterMark,
 		sgmtsize: config.WalSegmentSize,
-		file:     file,
+		policy:   config.WalSyncPolicy,
+		group:    newGroupCommit(),
+		stop:     make(chan struct{}),
 	}
-}
-
-func (w *WAL) WriteRecord(record Record) error {
-	buf := new(bytes.Buffer)
-
-	// Compute CRC
-	forCRC := append(record.Key, record.Value...)
-	crc := CRC32(forCRC)
-
-	// Write CRC
-	err := binary.Write(buf, binary.BigEndian, crc)
+	paths, err := listSegments(logPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Write Timestamp
-	err = binary.Write(buf, binary.BigEndian, record.Timestamp.Unix())
-	if err != nil {
-		return err
+	// log se cita do kraja da bi se nasla poslednja sekvenca i mesto za nastavak upisa
+	// za svaku familiju se pamti prvi zapis iza njene poslednje oznake praznjenja
+	r := newReader(paths, Position{})
+	unflushed := make(map[uint32]Position)
+	for err == nil {
+		var record *Record
+		record, err = r.Next()
+		if err != nil {
+			break
+		}
+		if record.Type == FlushRecord {
+			delete(unflushed, record.Family)
+			continue
+		}
+		changes, uerr := record.Unbatch()
+		if uerr != nil {
+			continue
+		}
+		for _, c := range changes {
+			if _, ok := unflushed[c.Family]; !ok {
+				unflushed[c.Family] = r.Position()
+			}
+		}
+	}
+	w.seq = r.lastSeq
+	w.flushed = r.End()
+	for _, pos := range unflushed {
+		if pos.before(w.flushed) {
+			w.flushed = pos
+		}
+	}
+	if cerr, ok := err.(*CorruptionError); ok && cerr.Torn {
+		// nepotpun zapis na kraju loga, upis je prekinut pa se odbacuje
+		err = w.discardTail(paths, r.endSeg, r.endOffset)
+		// fragmenti odbacenog zapisa ne smeju imati isti tag kao novi segmenti
+		w.seq++
+	}
+	if err != io.EOF && err != nil {
+		return nil, err
 	}
 
-	// Write Tombstone
-	if record.Tombstone {
-		buf.WriteByte(1)
+	if len(paths) == 0 {
+		err = w.createSegment(0)
 	} else {
-		buf.WriteByte(0)
+		err = w.openTail(r.endSeg, r.endOffset)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if record.FlushFlag {
-		buf.WriteByte(1)
-	} else {
-		buf.WriteByte(0)
+	if w.policy == SyncPeriodic {
+		go w.syncPeriodically(time.Duration(config.WalSyncInterval) * time.Millisecond)
+	}
+	return w, nil
+}
+
+// Brise sadrzaj loga iza poslednjeg celog zapisa
+func (w *WAL) discardTail(paths []string, seg *segment, offset int64) error {
+	last := slices.Index(paths, seg.path)
+	for _, path := range paths[last+1:] {
+		if err := w.recycle(path); err != nil {
+			return err
+		}
+	}
+	if seg.version != Version {
+		return os.Truncate(seg.path, offset)
 	}
 
-	// Write Key Size
-	err = binary.Write(buf, binary.BigEndian, int64(len(record.Key)))
+	file, err := os.OpenFile(seg.path, os.O_RDWR, 0777)
 	if err != nil {
 		return err
 	}
-
-	// Write Value Size
-	err = binary.Write(buf, binary.BigEndian, int64(len(record.Value)))
+	defer file.Close()
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
+	if _, err = file.WriteAt(make([]byte, info.Size()-offset), offset); err != nil {
+		return err
+	}
+	return file.Sync()
+}
 
-	// Write Key
-	buf.Write(record.Key)
-
-	// Write Value
-	buf.Write(record.Value)
+// Nastavlja upis iza poslednjeg celog zapisa
+func (w *WAL) openTail(seg *segment, offset int64) error {
+	index, _ := segmentIndex(seg.path)
+	if seg.version != Version {
+		if offset == 0 {
+			// segment bez ijednog zapisa se ponovo koristi
+			return w.createSegment(index)
+		}
+		// u segment stare verzije se ne dopisuje
+		return w.createSegment(index + 1)
+	}
 
-	// Append to the log file
-	_, err = w.file.Write(buf.Bytes())
+	file, err := os.OpenFile(seg.path, os.O_RDWR, 0777)
 	if err != nil {
 		return err
 	}
-
-	// Need to check if the segment is now full
-	fileInfo, err := w.file.Stat()
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return err
 	}
-	if fileInfo.Size() >= w.sgmtsize {
-		err := w.file.Close()
-		if err != nil {
-			return err
-		}
-		w.index++
-		filename := fp.Join(w.dir, fmt.Sprintf("wal_%03d", w.index))
-		file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0777)
-		if err != nil {
-			return err
-		}
-		w.file = file
-	}
-
+	w.file, w.index = file, index
+	w.offset, w.limit = offset, info.Size()
+	w.tag = fragmentTag(seg.startSeq)
 	return nil
 }
 
-// pitaj ih jel bi radije da vraca listu recordsa
-func (w *WAL) ReadWAL() ([]Record, error) {
-	files, err := fp.Glob(fp.Join(w.dir, "wal_*"))
-	if err != nil {
-		return nil, err
-	}
-
-	sort.Slice(files, func(i, j int) bool {
-		var index1, index2 int
-		fmt.Sscanf(fp.Base(files[i]), "wal_%03d", &index1)
-		fmt.Sscanf(fp.Base(files[j]), "wal_%03d", &index2)
-		return index1 < index2
-	})
-
-	var records []Record
-
-	for _, file := range files {
-		file, err := os.Open(file)
-		if err != nil {
-			return nil, err
+// Zaustavlja periodicnu sinhronizaciju i zatvara segment, log se posle ne koristi
+// Ponovni poziv nema efekta
+func (w *WAL) Close() error {
+	var err error
+	w.closed.Do(func() {
+		close(w.stop)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.policy != SyncNone {
+			w.file.Sync()
 		}
+		err = w.file.Close()
+	})
+	return err
+}
 
-		for {
-			var crc uint32
-			err := binary.Read(file, binary.BigEndian, &crc)
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				fmt.Println("Greška prilikom čitanja crc:", err)
-				return nil, err
-			}
-
-			var timestampUnix int64
-			err = binary.Read(file, binary.BigEndian, &timestampUnix)
-			if err != nil {
-				fmt.Println("Greška prilikom čitanja vremenske oznake:", err)
-				return nil, err
-			}
-			timestamp := time.Unix(timestampUnix, 0)
-
-			var tombstoneByte byte
-			err = binary.Read(file, binary.BigEndian, &tombstoneByte)
-			if err != nil {
-				fmt.Println("Greška prilikom čitanja Tombstone:", err)
-				return nil, err
-			}
-			tombstone := tombstoneByte == 1
-
-			var flushFlagByte byte
-			err = binary.Read(file, binary.BigEndian, &flushFlagByte)
-			if err != nil {
-				fmt.Println("Greska pri citanju flush flag-a", err)
-				return nil, err
-			}
-			flushFlag := flushFlagByte == 1
-
-			var keySize int64
-			err = binary.Read(file, binary.BigEndian, &keySize)
-			if err != nil {
-				fmt.Println("Greška prilikom čitanja veličine ključa:", err)
-				return nil, err
-			}
-
-			var valueSize int64
-			err = binary.Read(file, binary.BigEndian, &valueSize)
-			if err != nil {
-				fmt.Println("Greška prilikom čitanja veličine vrednosti:", err)
-				return nil, err
-			}
-
-			key := make([]byte, keySize)
-			_, err = file.Read(key)
-			if err != nil {
-				fmt.Println("Greška prilikom čitanja ključa:", err)
-				return nil, err
-			}
-
-			value := make([]byte, valueSize)
-			_, err = file.Read(value)
-			if err != nil {
-				fmt.Println("Greška prilikom čitanja vrednosti:", err)
-				return nil, err
-			}
-
-			record := Record{
-				FlushFlag: flushFlag,
-				Timestamp: timestamp,
-				Tombstone: tombstone,
-				Key:       key,
-				Value:     value,
-			}
-
-			records = append(records, record)
-		}
-		file.Close()
-	}
+// Pozicija prvog zapisa u logu zatecenom pri otvaranju koji nije upisan u SSTabele svoje familije
+// Od nje se izmene ponovo primenjuju pri oporavku
+func (w *WAL) FlushPosition() Position {
+	return w.flushed
+}
 
-	// for _, entry := range records {
-	// 	fmt.Printf("Timestamp: %s, Tombstone: %v, Key: %s, Value: %s\n",
-	// 		entry.Timestamp.Format(time.RFC3339), entry.Tombstone, entry.Key, entry.Value)
-	// }
-	return records, nil
+func (w *WAL) segmentPath(index int) string {
+	return fp.Join(w.dir, fmt.Sprintf("wal_%03d", index))
 }
 
-func (w *WAL) CleanUpWal() {
-	files, err := fp.Glob(fp.Join(w.dir, "wal_*"))
+func (w *WAL) createSegment(index int) error {
+	file, err := w.openSegment(index)
 	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	//If there is less than w.lwm wal segments we won't remove any
-	if len(files) <= w.lwm {
-		fmt.Println("Nema dovoljno segmenata za ciscenje.")
-		return
+		return err
 	}
 
-	// slices.Reverse(files)
-	// Remove files with index lower than the low watermark
-	for _, file := range files {
-		var index int
-		_, err := fmt.Sscanf(fp.Base(file), "wal_%03d", &index)
-		if err == nil && index <= w.lwm {
-			err := os.Remove(file)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-		}
+	header := make([]byte, SegmentHeaderSize)
+	copy(header, segmentMagic)
+	header[len(segmentMagic)] = Version
+	binary.BigEndian.PutUint64(header[len(segmentMagic)+1:], w.seq+1)
+	_, err = file.WriteAt(header, 0)
+	if err != nil {
+		file.Close()
+		return err
 	}
 
-	files, _ = fp.Glob(fp.Join(w.dir, "wal_*"))
+	w.index = index
+	w.file = file
+	w.offset, w.limit = SegmentHeaderSize, w.sgmtsize
+	w.tag = fragmentTag(w.seq + 1)
+	return nil
+}
 
-	// Update indexes to start with 0 again
-	for i, file := range files {
-		newIndex := i
-		newFilename := fp.Join(w.dir, fmt.Sprintf("wal_%03d", newIndex))
-		err := os.Rename(file, newFilename)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		w.index = newIndex
+// Upis zapisa u log, zapisu se dodeljuje sledeca sekvenca
+// Kada se metoda vrati zapis je na disku u skladu sa WalSyncPolicy
+func (w *WAL) WriteRecord(record *Record) error {
+	pos, err := w.AppendRecord(record)
+	if err != nil {
+		return err
 	}
+	return w.WaitSynced(pos)
 }
 
-func (w *WAL) ReadSegment(path string) ([]Record, error) {
-	file, err := os.OpenFile(path, os.O_RDONLY, 066)
-	var records []Record
+// Kao WriteRecord, ali pri grupnom commit-u ne ceka da zapis bude na disku
+// Vraca poziciju kraja zapisa u logu, za koju treba pozvati WaitSynced
+func (w *WAL) AppendRecord(record *Record) (uint64, error) {
+	count, err := record.seqCount()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	for {
-		var crc uint32
-		err := binary.Read(file, binary.BigEndian, &crc)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Println("Greška prilikom čitanja crc:", err)
-			return nil, err
-		}
-
-		var timestampUnix int64
-		err = binary.Read(file, binary.BigEndian, &timestampUnix)
-		if err != nil {
-			fmt.Println("Greška prilikom čitanja vremenske oznake:", err)
-			return nil, err
-		}
-		timestamp := time.Unix(timestampUnix, 0)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	record.Seq = w.seq + 1
+	// sekvenca se zauzima pre upisa, segmenti otvoreni tokom upisa pocinju od sledece
+	w.seq += count
+	return w.append(encodeRecord(record))
+}
 
-		var tombstoneByte byte
-		err = binary.Read(file, binary.BigEndian, &tombstoneByte)
-		if err != nil {
-			fmt.Println("Greška prilikom čitanja Tombstone:", err)
-			return nil, err
-		}
-		tombstone := tombstoneByte == 1
+// Pozicija kraja poslednjeg upisa u logu
+func (w *WAL) Written() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
 
-		var flushFlagByte byte
-		err = binary.Read(file, binary.BigEndian, &flushFlagByte)
-		if err != nil {
-			fmt.Println("Greska pri citanju flush flag-a", err)
-			return nil, err
+// Dopisuje zapis u log, deli ga na fragmente ako ne staje u trenutni segment
+// Vraca poziciju kraja upisa u logu
+func (w *WAL) append(data []byte) (uint64, error) {
+	first := true
+	for {
+		space := w.limit - w.offset - FragmentHeaderSize
+		if space <= 0 {
+			if err := w.rotate(); err != nil {
+				return 0, err
+			}
+			continue
 		}
-		flushFlag := flushFlagByte == 1
 
-		var keySize int64
-		err = binary.Read(file, binary.BigEndian, &keySize)
-		if err != nil {
-			fmt.Println("Greška prilikom čitanja veličine ključa:", err)
-			return nil, err
+		n := min(int64(len(data)), space)
+		last := n == int64(len(data))
+		kind := middleFragment
+		if first && last {
+			kind = fullFragment
+		} else if first {
+			kind = firstFragment
+		} else if last {
+			kind = lastFragment
 		}
 
-		var valueSize int64
-		err = binary.Read(file, binary.BigEndian, &valueSize)
+		written, err := w.file.WriteAt(encodeFragment(w.tag, kind, data[:n]), w.offset)
+		w.offset += int64(written)
+		w.written += uint64(written)
 		if err != nil {
-			fmt.Println("Greška prilikom čitanja veličine vrednosti:", err)
-			return nil, err
+			return 0, err
 		}
-
-		key := make([]byte, keySize)
-		_, err = file.Read(key)
-		if err != nil {
-			fmt.Println("Greška prilikom čitanja ključa:", err)
-			return nil, err
+		data = data[n:]
+		first = false
+		if last {
+			break
 		}
+	}
 
-		value := make([]byte, valueSize)
-		_, err = file.Read(value)
-		if err != nil {
-			fmt.Println("Greška prilikom čitanja vrednosti:", err)
-			return nil, err
+	if w.policy == SyncAlways {
+		if err := w.file.Sync(); err != nil {
+			return 0, err
 		}
+	}
+	return w.written, nil
+}
 
-		record := Record{
-			FlushFlag: flushFlag,
-			Timestamp: timestamp,
-			Tombstone: tombstone,
-			Key:       key,
-			Value:     value,
+// Zatvara popunjen segment i otvara sledeci
+func (w *WAL) rotate() error {
+	if w.policy != SyncNone {
+		// segment koji se zatvara mora biti na disku
+		if err := w.file.Sync(); err != nil {
+			return err
 		}
-
-		records = append(records, record)
+		w.group.markSynced(w.written)
 	}
-	file.Close()
-
-	return records, nil
-
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.createSegment(w.index + 1)
 }
 
-func (w *WAL) Recover() ([]Record, error) {
-	recovery_log := make([]Record, 0)
-	wal_dir, err := os.Open(w.dir)
+// Atomican upis vise izmena, izmene dobijaju uzastopne sekvence
+func (w *WAL) WriteBatch(records []*Record) error {
+	batch := NewBatch(records)
+	err := w.WriteRecord(batch)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	segments, err := wal_dir.ReadDir(0)
-	if err != nil {
-		return nil, err
+	for i, r := range records {
+		r.Seq = batch.Seq + uint64(i)
 	}
-	segment_paths := make([]string, 0)
-	for _, v := range segments {
-		segment_paths = append(segment_paths, fp.Join(w.dir, v.Name()))
+	return nil
+}
+
+// Upisuje oznaku praznjenja memtabele familije, izmene familije do sekvence seq su u SSTabelama
+func (w *WAL) MarkFlushed(family uint32, seq uint64) error {
+	return w.WriteRecord(NewFlushRecord(family, seq))
+}
+
+// Uklanja segmente ciji su svi zapisi, svih familija, do sekvence seq (ukljucujuci)
+func (w *WAL) Reclaim(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	paths, err := listSegments(w.dir)
+	if err != nil {
+		return err
 	}
-	sort.StringSlice.Sort(segment_paths)
 
-	for i := len(segment_paths) - 1; i >= 0; i-- {
-		segment, err := w.ReadSegment(segment_paths[i])
+	// zapisi segmenta imaju sekvence manje od pocetne sekvence sledeceg segmenta
+	for i := 0; i+1 < len(paths); i++ {
+		if index, _ := segmentIndex(paths[i]); index == w.index {
+			break
+		}
+		next, err := readStartSeq(paths[i+1])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		for j := len(segment) - 1; j >= 0; j-- {
-			if segment[j].FlushFlag {
-				slices.Reverse(recovery_log)
-				return recovery_log, nil
-			}
-			recovery_log = append(recovery_log, segment[j])
+		if next > seq+1 {
+			break
+		}
+		if err = w.recycle(paths[i]); err != nil {
+			return err
 		}
 	}
-
-	slices.Reverse(recovery_log)
-	return recovery_log, nil
+	return nil
 }
@@ -0,0 +1,419 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-touch-grass/config"
+	"io"
+	"os"
+	fp "path/filepath"
+	"testing"
+)
+
+func newTestWAL(b *testing.B, policy string) *WAL {
+	w, err := New(b.TempDir(), &config.Config{
+		WalSegmentSize:  1 << 20,
+		WalSyncPolicy:   policy,
+		WalSyncInterval: 10,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return w
+}
+
+func benchmarkWrite(b *testing.B, policy string) {
+	w := newTestWAL(b, policy)
+	defer w.Close()
+
+	key := []byte("benchmark-key")
+	value := make([]byte, 100)
+	b.SetBytes(int64(RecordHeaderSize + len(key) + len(value)))
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := w.WriteRecord(NewRecord(PutRecord, key, value))
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkWriteSyncNone(b *testing.B) {
+	benchmarkWrite(b, SyncNone)
+}
+
+func BenchmarkWriteSyncAlways(b *testing.B) {
+	benchmarkWrite(b, SyncAlways)
+}
+
+func BenchmarkWriteSyncGroup(b *testing.B) {
+	benchmarkWrite(b, SyncGroup)
+}
+
+func BenchmarkWriteSyncPeriodic(b *testing.B) {
+	benchmarkWrite(b, SyncPeriodic)
+}
+
+const testSegmentSize = 64
+
+func openTestWAL(t *testing.T, dir string) *WAL {
+	return openTestWALSize(t, dir, testSegmentSize)
+}
+
+func openTestWALSize(t *testing.T, dir string, size int64) *WAL {
+	w, err := New(dir, &config.Config{
+		WalSegmentSize: size,
+		WalSyncPolicy:  SyncNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// Upisuje n zapisa dovoljno velikih da se podele na fragmente u vise segmenata
+func writeTestRecords(t *testing.T, w *WAL, n int) {
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if err := w.WriteRecord(NewRecord(PutRecord, []byte(key), []byte(key+"-value"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestRecords(dir string, from Position) ([]*Record, error) {
+	paths, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := newReader(paths, from)
+	var records []*Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	writeTestRecords(t, w, 10)
+	w.Close()
+
+	paths, _ := listSegments(dir)
+	if len(paths) < 3 {
+		t.Fatalf("%d segmenata, zapisi nisu podeljeni na vise segmenata", len(paths))
+	}
+	for _, path := range paths {
+		if info, _ := os.Stat(path); info.Size() != testSegmentSize {
+			t.Errorf("segment %s ima %d bajtova", path, info.Size())
+		}
+	}
+
+	records, err := readTestRecords(dir, Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10 {
+		t.Fatalf("procitano %d zapisa, ocekivano 10", len(records))
+	}
+	for i, record := range records {
+		key := fmt.Sprintf("key-%02d", i)
+		if string(record.Key) != key || string(record.Value) != key+"-value" || record.Seq != uint64(i+1) {
+			t.Errorf("zapis %d: %s=%s seq %d", i, record.Key, record.Value, record.Seq)
+		}
+	}
+
+	// upis se nastavlja iza poslednjeg zapisa
+	w = openTestWAL(t, dir)
+	w.WriteRecord(NewRecord(PutRecord, []byte("next"), nil))
+	w.Close()
+	records, err = readTestRecords(dir, Position{})
+	if err != nil || len(records) != 11 || records[10].Seq != 11 {
+		t.Errorf("posle ponovnog otvaranja procitano %d zapisa, greska %v", len(records), err)
+	}
+}
+
+// Pozicija i kraj fragmenta u segmentu
+func testFragment(t *testing.T, path string, i int) (int64, int64) {
+	seg, _ := readSegment(path)
+	if i < 0 {
+		i += len(seg.fragments)
+	}
+	if i < 0 || i >= len(seg.fragments) {
+		t.Fatalf("segment %s nema fragment %d", path, i)
+	}
+	return seg.fragments[i].offset, seg.fragments[i].end
+}
+
+func TestCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64 // velicina segmenta, podrazumevano testSegmentSize
+		corrupt func(t *testing.T, paths []string)
+		torn    bool // greska se ignorise i zapisi posle ostecenja se odbacuju
+		records int  // broj zapisa procitanih posle ponovnog otvaranja
+	}{
+		{
+			name: "CRC poslednjeg fragmenta",
+			corrupt: func(t *testing.T, paths []string) {
+				last := paths[len(paths)-1]
+				offset, _ := testFragment(t, last, -1)
+				flipByte(t, last, offset+FragmentHeaderSize)
+			},
+			torn:    true,
+			records: 9,
+		},
+		{
+			name: "prekinut upis poslednjeg fragmenta",
+			corrupt: func(t *testing.T, paths []string) {
+				last := paths[len(paths)-1]
+				offset, end := testFragment(t, last, -1)
+				zeroBytes(t, last, (offset+end)/2, end)
+			},
+			torn:    true,
+			records: 9,
+		},
+		{
+			name: "CRC usred loga",
+			corrupt: func(t *testing.T, paths []string) {
+				offset, _ := testFragment(t, paths[0], 0)
+				flipByte(t, paths[0], offset+FragmentHeaderSize)
+			},
+		},
+		{
+			name: "duzina fragmenta usred loga",
+			corrupt: func(t *testing.T, paths []string) {
+				offset, _ := testFragment(t, paths[0], 0)
+				writeBytes(t, paths[0], offset+8, []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			// iza ostecenog fragmenta u istom segmentu slede ispravni
+			name: "duzina fragmenta usred poslednjeg segmenta",
+			size: 4096,
+			corrupt: func(t *testing.T, paths []string) {
+				offset, _ := testFragment(t, paths[0], 0)
+				writeBytes(t, paths[0], offset+8, []byte{0xff, 0xff, 0xff, 0xff})
+			},
+		},
+		{
+			name: "duzina fragmenta na kraju loga",
+			corrupt: func(t *testing.T, paths []string) {
+				last := paths[len(paths)-1]
+				offset, _ := testFragment(t, last, -1)
+				writeBytes(t, last, offset+8, []byte{0xff, 0xff, 0xff, 0xff})
+			},
+			torn:    true,
+			records: 9,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			size := test.size
+			if size == 0 {
+				size = testSegmentSize
+			}
+			w := openTestWALSize(t, dir, size)
+			writeTestRecords(t, w, 10)
+			w.Close()
+			paths, _ := listSegments(dir)
+			test.corrupt(t, paths)
+			checkCorruption(t, dir, test.torn, test.records)
+		})
+	}
+}
+
+// Segmenti verzije 2 nemaju fragmente, velicina zapisa se cita iz zaglavlja zapisa
+func TestCorruptionVersion2(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  int // zapis cije se polje velicine kljuca menja
+		torn    bool
+		records int
+	}{
+		{name: "velicina kljuca usred segmenta", record: 0},
+		{name: "velicina kljuca poslednjeg zapisa", record: 4, torn: true, records: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data := make([]byte, SegmentHeaderSize)
+			copy(data, segmentMagic)
+			data[len(segmentMagic)] = 2
+			binary.BigEndian.PutUint64(data[len(segmentMagic)+1:], 1)
+			for i := 0; i < 5; i++ {
+				record := NewRecord(PutRecord, []byte(fmt.Sprint("key-", i)), []byte("value"))
+				record.Seq = uint64(i + 1)
+				encoded := encodeRecord(record)
+				if i == test.record {
+					binary.BigEndian.PutUint64(encoded[KeySizeStart:], 1<<20)
+				}
+				data = append(data, encoded...)
+			}
+			if err := os.WriteFile(fp.Join(dir, "wal_000"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			checkCorruption(t, dir, test.torn, test.records)
+		})
+	}
+}
+
+func checkCorruption(t *testing.T, dir string, torn bool, count int) {
+	_, err := readTestRecords(dir, Position{})
+	cerr, ok := err.(*CorruptionError)
+	if !ok {
+		t.Fatalf("citanje vraca %v, ocekivana CorruptionError", err)
+	}
+	if cerr.Torn != torn {
+		t.Fatalf("Torn = %v, ocekivano %v", cerr.Torn, torn)
+	}
+
+	w, err := New(dir, &config.Config{WalSegmentSize: testSegmentSize, WalSyncPolicy: SyncNone})
+	if !torn {
+		if _, ok := err.(*CorruptionError); !ok {
+			t.Fatalf("otvaranje loga vraca %v, ocekivana CorruptionError", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	records, err := readTestRecords(dir, Position{})
+	if err != nil || len(records) != count {
+		t.Errorf("posle odbacivanja kraja procitano %d zapisa, greska %v, ocekivano %d", len(records), err, count)
+	}
+}
+
+func flipByte(t *testing.T, path string, offset int64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+	os.WriteFile(path, data, 0644)
+}
+
+func zeroBytes(t *testing.T, path string, from int64, to int64) {
+	writeBytes(t, path, from, make([]byte, to-from))
+}
+
+func writeBytes(t *testing.T, path string, offset int64, value []byte) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[offset:], value)
+	os.WriteFile(path, data, 0644)
+}
+
+func TestReclaim(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	defer w.Close()
+	writeTestRecords(t, w, 20)
+
+	before, _ := listSegments(dir)
+	if err := w.Reclaim(10); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := listSegments(dir)
+	free, _ := fp.Glob(fp.Join(dir, "free_*"))
+	if len(after) >= len(before) {
+		t.Fatalf("nijedan segment nije uklonjen (%d segmenata)", len(after))
+	}
+	if len(free) == 0 || len(free) > recycleLimit {
+		t.Errorf("%d segmenata sacuvano za ponovnu upotrebu", len(free))
+	}
+
+	// zapisi posle sekvence 10 ostaju u logu
+	records, err := readTestRecords(dir, Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := make(map[uint64]bool)
+	for _, record := range records {
+		seqs[record.Seq] = true
+	}
+	for seq := uint64(11); seq <= 20; seq++ {
+		if !seqs[seq] {
+			t.Errorf("zapis sa sekvencom %d je uklonjen", seq)
+		}
+	}
+
+	// novi segmenti koriste sacuvane fajlove, stari fragmenti u njima imaju drugi tag
+	writeTestRecords(t, w, 10)
+	reused, _ := fp.Glob(fp.Join(dir, "free_*"))
+	if len(reused) >= len(free) {
+		t.Errorf("sacuvani segmenti nisu ponovo iskorisceni")
+	}
+	records, err = readTestRecords(dir, Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := records[len(records)-1]; last.Seq != 30 {
+		t.Errorf("poslednji zapis ima sekvencu %d, ocekivano 30", last.Seq)
+	}
+}
+
+func TestFlushPosition(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	writeTestRecords(t, w, 5)
+	w.MarkFlushed(0, 5)
+
+	unflushed := NewRecord(PutRecord, []byte("family"), []byte("1"))
+	unflushed.Family = 1
+	w.WriteRecord(unflushed)
+	writeTestRecords(t, w, 5)
+	w.MarkFlushed(0, 12)
+	writeTestRecords(t, w, 2)
+	w.Close()
+
+	// oporavak pocinje od prvog zapisa familije 1, jer za nju nema oznake praznjenja
+	w = openTestWAL(t, dir)
+	records, err := readTestRecords(dir, w.FlushPosition())
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || string(records[0].Key) != "family" {
+		t.Fatalf("oporavak ne pocinje od zapisa familije 1: %v", records)
+	}
+	// 1 zapis familije 1, 5 zapisa, oznaka praznjenja i 2 zapisa posle nje
+	if len(records) != 9 {
+		t.Errorf("od pozicije oporavka procitano %d zapisa, ocekivano 9", len(records))
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	for _, policy := range []string{SyncNone, SyncPeriodic} {
+		w, err := New(t.TempDir(), &config.Config{
+			WalSegmentSize:  1024,
+			WalSyncPolicy:   policy,
+			WalSyncInterval: 10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Errorf("%s: drugo zatvaranje: %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package menu
+
+import (
+	"bufio"
+	"fmt"
+	"go-touch-grass/internal/app"
+	"go-touch-grass/internal/util"
+	"strconv"
+)
+
+func (m *Menu) HandleCms(sc *bufio.Scanner, app *app.App) {
+	fmt.Println("1 Kreiraj CMS")
+	fmt.Println("2 Dodaj podatak u CMS")
+	fmt.Println("3 Proceni frekvenciju podatka")
+	fmt.Println("4 Obrisi CMS")
+	fmt.Print("Izaberite opciju: ")
+	c := util.ScanLowerString(sc)
+	if c != "1" && c != "2" && c != "3" && c != "4" {
+		util.Print("Niste uneli validnu opciju.")
+		return
+	}
+
+	fmt.Print("Unesite naziv CMS: ")
+	name := util.ScanString(sc)
+	if name == "" {
+		fmt.Println("greska: neispravan naziv")
+		return
+	}
+
+	var err error
+	switch c {
+	case "1":
+		err = app.CreateCMS(name)
+		if err == nil {
+			util.Print("CMS [", name, "] uspesno kreiran.")
+		}
+	case "2":
+		fmt.Print("Unesite podatak: ")
+		value := util.ScanString(sc)
+		err = app.AddToCMS(name, value)
+		if err == nil {
+			util.Print("Podatak uspesno dodat u CMS [", name, "].")
+		}
+	case "3":
+		fmt.Print("Unesite podatak: ")
+		value := util.ScanString(sc)
+		var count uint64
+		count, err = app.EstimateCMS(name, value)
+		if err == nil {
+			util.Print("Procenjena frekvencija: ", strconv.FormatUint(count, 10))
+		}
+	case "4":
+		err = app.DeleteCMS(name)
+		if err == nil {
+			util.Print("CMS [", name, "] obrisan.")
+		}
+	}
+	if err != nil {
+		util.Print("greska: ", err.Error())
+	}
+}
@@ -0,0 +1,59 @@
+package menu
+
+import (
+	"bufio"
+	"fmt"
+	"go-touch-grass/internal/app"
+	"go-touch-grass/internal/util"
+	"strconv"
+)
+
+func (m *Menu) HandleHll(sc *bufio.Scanner, app *app.App) {
+	fmt.Println("1 Kreiraj HLL")
+	fmt.Println("2 Dodaj podatak u HLL")
+	fmt.Println("3 Proceni broj razlicitih podataka")
+	fmt.Println("4 Obrisi HLL")
+	fmt.Print("Izaberite opciju: ")
+	c := util.ScanLowerString(sc)
+	if c != "1" && c != "2" && c != "3" && c != "4" {
+		util.Print("Niste uneli validnu opciju.")
+		return
+	}
+
+	fmt.Print("Unesite naziv HLL: ")
+	name := util.ScanString(sc)
+	if name == "" {
+		fmt.Println("greska: neispravan naziv")
+		return
+	}
+
+	var err error
+	switch c {
+	case "1":
+		err = app.CreateHLL(name)
+		if err == nil {
+			util.Print("HLL [", name, "] uspesno kreiran.")
+		}
+	case "2":
+		fmt.Print("Unesite podatak: ")
+		value := util.ScanString(sc)
+		err = app.AddToHLL(name, value)
+		if err == nil {
+			util.Print("Podatak uspesno dodat u HLL [", name, "].")
+		}
+	case "3":
+		var count float64
+		count, err = app.EstimateHLL(name)
+		if err == nil {
+			util.Print("Procenjen broj razlicitih podataka: ", strconv.FormatFloat(count, 'f', 0, 64))
+		}
+	case "4":
+		err = app.DeleteHLL(name)
+		if err == nil {
+			util.Print("HLL [", name, "] obrisan.")
+		}
+	}
+	if err != nil {
+		util.Print("greska: ", err.Error())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"go-touch-grass/internal/app"
 	"go-touch-grass/internal/util"
 	"os"
+	"time"
 )
 
 type Menu struct {
@@ -21,20 +22,38 @@ func (m *Menu) PrintMenu() {
 	fmt.Println("2 Pronadji podatak")
 	fmt.Println("3 Obrisi podatak")
 	fmt.Println("4 Pokreni kompakciju")
-	fmt.Println("5 Pokreni ciscenje WAL")
+	fmt.Println("5 Count-Min Sketch")
+	fmt.Println("6 HyperLogLog")
+	fmt.Println("7 SimHash")
+	fmt.Println("8 Proveri integritet SSTabela")
+	fmt.Println("9 Uporedi sa drugim direktorijumom podataka")
+	fmt.Println("10 Prikazi hash cele baze")
+	fmt.Println("11 Pregled WAL-a")
+	fmt.Println("12 Upisi podatak sa vremenom trajanja")
+	fmt.Println("13 Uslovni upis")
+	fmt.Println("14 Spoji izmenu sa vrednoscu")
+	fmt.Println("15 Izaberi familiju kolona")
+	fmt.Println("16 Statistika kesa")
 	fmt.Println()
 	fmt.Println("q Izadji")
 	fmt.Println("----------------------------")
 }
 
 func (m *Menu) Show() {
-	app, err := app.New()
+	sc := bufio.NewScanner(os.Stdin)
+	app, err := app.NewWithProgress(func(segments int, records int) {
+		fmt.Printf("\rOporavak iz WAL-a: %d segmenata, %d zapisa", segments, records)
+	})
+	fmt.Println()
 	if err != nil {
 		fmt.Println(err.Error())
+		fmt.Print("Pregledati WAL (Y/n): ")
+		if util.ScanLowerString(sc) != "n" {
+			m.HandleWalInspect(sc)
+		}
 		return
 	}
 
-	sc := bufio.NewScanner(os.Stdin)
 	for {
 		m.PrintMenu()
 		fmt.Print("Izaberite opciju: ")
@@ -50,8 +69,31 @@ func (m *Menu) Show() {
 		case "4":
 			m.HandleCompaction(sc, app)
 		case "5":
-			m.HandleWalCleanup(sc, app)
+			m.HandleCms(sc, app)
+		case "6":
+			m.HandleHll(sc, app)
+		case "7":
+			m.HandleSimHash(sc, app)
+		case "8":
+			m.HandleVerifyIntegrity(sc, app)
+		case "9":
+			m.HandleCompareDataDir(sc, app)
+		case "10":
+			m.HandleDatabaseHash(sc, app)
+		case "11":
+			m.HandleWalInspect(sc)
+		case "12":
+			m.HandlePutWithTTL(sc, app)
+		case "13":
+			m.HandleConditionalPut(sc, app)
+		case "14":
+			m.HandleMerge(sc, app)
+		case "15":
+			app = m.HandleColumnFamily(sc, app)
+		case "16":
+			m.HandleCacheStats(app)
 		case "q":
+			app.Close()
 			return
 		default:
 			util.Print("Niste uneli validnu opciju.")
@@ -80,6 +122,115 @@ func (m *Menu) HandlePut(sc *bufio.Scanner, app *app.App) {
 	}
 }
 
+func (m *Menu) HandlePutWithTTL(sc *bufio.Scanner, app *app.App) {
+	fmt.Print("Unesite kljuc: ")
+	key := util.ScanString(sc)
+	if key == "" {
+		fmt.Println("greska: neispravan kljuc")
+		return
+	}
+
+	fmt.Print("Unesite podatke: ")
+	value := util.ScanString(sc)
+
+	fmt.Print("Unesite vreme trajanja u sekundama: ")
+	seconds := util.ScanInt(sc)
+	if seconds <= 0 {
+		fmt.Println("greska: neispravno vreme trajanja")
+		return
+	}
+
+	err := app.PutWithTTL(key, []byte(value), time.Duration(seconds)*time.Second)
+	if err != nil {
+		util.Print("greska: ", err.Error())
+	} else {
+		util.Print("Uspesno dodati podaci.")
+	}
+}
+
+func (m *Menu) HandleConditionalPut(sc *bufio.Scanner, app *app.App) {
+	fmt.Println("1 Zameni ako je trenutna vrednost jednaka ocekivanoj")
+	fmt.Println("2 Upisi ako kljuc ne postoji")
+	fmt.Print("Izaberite opciju: ")
+	c := util.ScanLowerString(sc)
+	if c != "1" && c != "2" {
+		util.Print("Niste uneli validnu opciju.")
+		return
+	}
+
+	fmt.Print("Unesite kljuc: ")
+	key := util.ScanString(sc)
+	if key == "" {
+		fmt.Println("greska: neispravan kljuc")
+		return
+	}
+
+	var expected []byte
+	if c == "1" {
+		fmt.Print("Unesite ocekivane podatke: ")
+		expected = []byte(util.ScanString(sc))
+	}
+	fmt.Print("Unesite nove podatke: ")
+	value := []byte(util.ScanString(sc))
+
+	var written bool
+	var err error
+	if c == "1" {
+		written, err = app.CompareAndSwap(key, expected, value)
+	} else {
+		written, err = app.PutIfAbsent(key, value)
+	}
+	if err != nil {
+		util.Print("greska: ", err.Error())
+	} else if written {
+		util.Print("Uspesno dodati podaci.")
+	} else {
+		util.Print("Uslov nije ispunjen, podaci nisu upisani.")
+	}
+}
+
+func (m *Menu) HandleMerge(sc *bufio.Scanner, app *app.App) {
+	fmt.Print("Unesite kljuc: ")
+	key := util.ScanString(sc)
+	if key == "" {
+		fmt.Println("greska: neispravan kljuc")
+		return
+	}
+
+	fmt.Print("Unesite izmenu: ")
+	operand := util.ScanString(sc)
+
+	err := app.Merge(key, []byte(operand))
+	if err != nil {
+		util.Print("greska: ", err.Error())
+	} else {
+		util.Print("Uspesno dodata izmena.")
+	}
+}
+
+// Vraca aplikaciju cije se operacije izvrsavaju nad izabranom familijom kolona
+func (m *Menu) HandleColumnFamily(sc *bufio.Scanner, a *app.App) *app.App {
+	fmt.Println("Trenutna familija kolona: " + a.FamilyName())
+	for i, name := range a.ColumnFamilies() {
+		fmt.Printf("%d %s\n", i+1, name)
+	}
+	fmt.Print("Izaberite familiju: ")
+	c := util.ScanInt(sc)
+	names := a.ColumnFamilies()
+	if c < 1 || c > len(names) {
+		util.Print("Niste uneli validnu opciju.")
+		return a
+	}
+
+	cf, err := a.ColumnFamily(names[c-1])
+	if err != nil {
+		util.Print("greska: ", err.Error())
+		return a
+	}
+	util.Print("Izabrana familija kolona: ", names[c-1])
+	return cf
+}
+
 func (m *Menu) HandleGet(sc *bufio.Scanner, app *app.App) {
 	fmt.Print("Unesite kljuc: ")
 	key := util.ScanString(sc)
@@ -130,15 +281,67 @@ func (m *Menu) HandleCompaction(sc *bufio.Scanner, app *app.App) {
 	}
 }
 
-func (m *Menu) HandleWalCleanup(sc *bufio.Scanner, app *app.App) {
-	fmt.Print("Potvrdi ciscenje (Y/n): ")
-	c := util.ScanLowerString(sc)
+func (m *Menu) HandleVerifyIntegrity(sc *bufio.Scanner, app *app.App) {
+	reports, err := app.VerifyIntegrity()
+	if err != nil {
+		util.Print("greska: ", err.Error())
+		return
+	}
+
+	for _, r := range reports {
+		if len(r.Corrupted) == 0 {
+			util.Print(r.TOCPath, ": ispravna")
+			continue
+		}
+		util.Print(r.TOCPath, ": ostecena")
+		for _, c := range r.Corrupted {
+			fmt.Printf("    deo %d, bajtovi [%d, %d)\n", c.Index, c.Start, c.End)
+		}
+	}
+}
 
-	if c == "n" {
+func (m *Menu) HandleCompareDataDir(sc *bufio.Scanner, app *app.App) {
+	fmt.Print("Unesite putanju do drugog direktorijuma podataka: ")
+	path := util.ScanString(sc)
+	if path == "" {
+		fmt.Println("greska: neispravna putanja")
 		return
-	} else if c != "y" {
-		fmt.Println("greska: nepostojeca opcija")
+	}
+
+	reports, err := app.CompareDataDir(path)
+	if err != nil {
+		util.Print("greska: ", err.Error())
+		return
+	}
+
+	if len(reports) == 0 {
+		util.Print("Podaci su identicni.")
 		return
 	}
-	app.CleanupWal()
+	for _, r := range reports {
+		if r.Missing {
+			util.Print(r.Table, ": postoji samo u jednom direktorijumu")
+		} else {
+			util.Print(r.Table, ": razlikuje se")
+		}
+		for _, c := range r.Chunks {
+			fmt.Printf("    deo %d, bajtovi [%d, %d)\n", c.Index, c.Start, c.End)
+		}
+	}
+}
+
+func (m *Menu) HandleCacheStats(app *app.App) {
+	stats := app.CacheStats()
+	fmt.Println("Familija kolona: " + app.FamilyName())
+	fmt.Printf("Pogodaka: %d (od toga za nepostojece kljuceve %d), promasaja: %d\n", stats.Hits, stats.NegativeHits, stats.Misses)
+	util.Print(fmt.Sprintf("Procenat pogodaka: %.2f%%", stats.HitRate()*100))
+}
+
+func (m *Menu) HandleDatabaseHash(sc *bufio.Scanner, app *app.App) {
+	hash, err := app.DatabaseHash()
+	if err != nil {
+		util.Print("greska: ", err.Error())
+	} else {
+		util.Print("Hash baze: ", hash)
+	}
 }
@@ -0,0 +1,49 @@
+package menu
+
+import (
+	"bufio"
+	"fmt"
+	"go-touch-grass/internal/app"
+	"go-touch-grass/internal/util"
+	"strconv"
+)
+
+func (m *Menu) HandleSimHash(sc *bufio.Scanner, app *app.App) {
+	fmt.Println("1 Sacuvaj otisak teksta")
+	fmt.Println("2 Hamingova udaljenost dva otiska")
+	fmt.Print("Izaberite opciju: ")
+	c := util.ScanLowerString(sc)
+
+	switch c {
+	case "1":
+		fmt.Print("Unesite kljuc: ")
+		key := util.ScanString(sc)
+		if key == "" {
+			fmt.Println("greska: neispravan kljuc")
+			return
+		}
+		fmt.Print("Unesite tekst: ")
+		text := util.ScanString(sc)
+
+		err := app.PutSimHash(key, text)
+		if err != nil {
+			util.Print("greska: ", err.Error())
+		} else {
+			util.Print("Otisak uspesno sacuvan.")
+		}
+	case "2":
+		fmt.Print("Unesite prvi kljuc: ")
+		key1 := util.ScanString(sc)
+		fmt.Print("Unesite drugi kljuc: ")
+		key2 := util.ScanString(sc)
+
+		distance, err := app.SimHashDistance(key1, key2)
+		if err != nil {
+			util.Print("greska: ", err.Error())
+		} else {
+			util.Print("Hamingova udaljenost: ", strconv.Itoa(distance))
+		}
+	default:
+		util.Print("Niste uneli validnu opciju.")
+	}
+}
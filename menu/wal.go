@@ -0,0 +1,92 @@
+package menu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go-touch-grass/internal/app"
+	"go-touch-grass/internal/util"
+	"go-touch-grass/internal/wal"
+	"io"
+	"os"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func (m *Menu) HandleWalInspect(sc *bufio.Scanner) {
+	fmt.Println("1 Citljiv ispis")
+	fmt.Println("2 JSON (jedan zapis po liniji)")
+	fmt.Print("Izaberite opciju: ")
+	c := util.ScanLowerString(sc)
+	if c != "1" && c != "2" {
+		util.Print("Niste uneli validnu opciju.")
+		return
+	}
+
+	var filter wal.Filter
+	var err error
+	fmt.Print("Prefiks kljuca (prazno za sve): ")
+	filter.KeyPrefix = util.ScanString(sc)
+	fmt.Print("Od vremena [" + timeLayout + "] (prazno bez ogranicenja): ")
+	if filter.From, err = scanTime(sc); err != nil {
+		util.Print("greska: neispravno vreme")
+		return
+	}
+	fmt.Print("Do vremena [" + timeLayout + "] (prazno bez ogranicenja): ")
+	if filter.To, err = scanTime(sc); err != nil {
+		util.Print("greska: neispravno vreme")
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	fmt.Print("Putanja izlaznog fajla (prazno za ispis): ")
+	if path := util.ScanString(sc); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			util.Print("greska: ", err.Error())
+			return
+		}
+		defer file.Close()
+		out = file
+	}
+
+	count := 0
+	encoder := json.NewEncoder(out)
+	err = app.InspectWal(filter, func(e wal.Entry) error {
+		count++
+		if c == "2" {
+			return encoder.Encode(e)
+		}
+		_, err := fmt.Fprintln(out, formatEntry(e))
+		return err
+	})
+	if err != nil {
+		util.Print("greska: ", err.Error())
+		return
+	}
+	util.Print("Broj zapisa: ", fmt.Sprint(count))
+}
+
+func scanTime(sc *bufio.Scanner) (time.Time, error) {
+	value := util.ScanString(sc)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(timeLayout, value, time.Local)
+}
+
+func formatEntry(e wal.Entry) string {
+	if !e.CrcValid {
+		return fmt.Sprintf("%s @%d  neispravan zapis (CRC)", e.Segment, e.Offset)
+	}
+	line := fmt.Sprintf("%s @%d  %s  %-6s seq=%d  kljuc=%q  vrednost=%dB  CRC ok",
+		e.Segment, e.Offset, e.Timestamp.Format("2006-01-02 15:04:05.000"), e.Type, e.Seq, e.Key, e.ValueSize)
+	if e.Family != 0 {
+		line += fmt.Sprintf("  familija=%d", e.Family)
+	}
+	if e.Expires != nil {
+		line += "  istice=" + e.Expires.Format(timeLayout)
+	}
+	return line
+}
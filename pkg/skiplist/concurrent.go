@@ -0,0 +1,132 @@
+package skiplist
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// ConcurrentSkipList allows parallel inserts and lock-free reads.
+// Nodes are never removed, so an insert only has to CAS the new node into
+// each level, starting from the bottom one where it becomes visible.
+//
+// Only Get, Put, GetAll and Size are safe to call concurrently. The memtable
+// built on top of it reads a key and then writes it, and keeps its own byte
+// counters, so inside the engine it is still used under the App lock and
+// behaves like the other containers there.
+type ConcurrentSkipList struct {
+	maxHeight int
+	size      atomic.Int64
+	head      atomic.Pointer[cnode]
+}
+
+type cnode struct {
+	key  string
+	data atomic.Pointer[interface{}]
+	next []atomic.Pointer[cnode] // next[0] is the bottom level
+}
+
+func NewConcurrent(maxHeight int) *ConcurrentSkipList {
+	s := &ConcurrentSkipList{maxHeight: maxHeight}
+	s.head.Store(newCnode("", nil, maxHeight))
+	return s
+}
+
+func newCnode(key string, data interface{}, height int) *cnode {
+	n := &cnode{key: key, next: make([]atomic.Pointer[cnode], height)}
+	n.data.Store(&data)
+	return n
+}
+
+// Fills preds and succs with the nodes around key on every level
+// and returns the node with the key if it exists
+func (s *ConcurrentSkipList) find(head *cnode, key string, preds []*cnode, succs []*cnode) *cnode {
+	pred := head
+	for i := s.maxHeight - 1; i >= 0; i-- {
+		curr := pred.next[i].Load()
+		for curr != nil && curr.key < key {
+			pred = curr
+			curr = pred.next[i].Load()
+		}
+		preds[i], succs[i] = pred, curr
+	}
+	if succs[0] != nil && succs[0].key == key {
+		return succs[0]
+	}
+	return nil
+}
+
+func (s *ConcurrentSkipList) Get(key string) (interface{}, bool) {
+	pred := s.head.Load()
+	for i := s.maxHeight - 1; i >= 0; i-- {
+		curr := pred.next[i].Load()
+		for curr != nil && curr.key < key {
+			pred = curr
+			curr = pred.next[i].Load()
+		}
+		if curr != nil && curr.key == key {
+			return *curr.data.Load(), true
+		}
+	}
+	return nil, false
+}
+
+func (s *ConcurrentSkipList) Put(key string, data interface{}) {
+	head := s.head.Load()
+	preds := make([]*cnode, s.maxHeight)
+	succs := make([]*cnode, s.maxHeight)
+	for {
+		if found := s.find(head, key, preds, succs); found != nil {
+			found.data.Store(&data) // update if key is found
+			return
+		}
+
+		height := s.roll()
+		n := newCnode(key, data, height)
+		n.next[0].Store(succs[0])
+		if !preds[0].next[0].CompareAndSwap(succs[0], n) {
+			// another insert changed the bottom level, search again
+			continue
+		}
+		s.size.Add(1)
+
+		for i := 1; i < height; i++ {
+			for {
+				n.next[i].Store(succs[i])
+				if preds[i].next[i].CompareAndSwap(succs[i], n) {
+					break
+				}
+				s.find(head, key, preds, succs)
+			}
+		}
+		return
+	}
+}
+
+func (s *ConcurrentSkipList) GetAll() []interface{} {
+	data := make([]interface{}, 0, s.Size())
+	for n := s.head.Load().next[0].Load(); n != nil; n = n.next[0].Load() {
+		data = append(data, *n.data.Load())
+	}
+	return data
+}
+
+func (s *ConcurrentSkipList) roll() int {
+	level := 1
+	for ; rand.Int31n(2) == 1; level++ {
+		if level >= s.maxHeight {
+			return level
+		}
+	}
+	return level
+}
+
+func (s *ConcurrentSkipList) Size() int {
+	return int(s.size.Load())
+}
+
+// Not safe to call while inserts are in progress, they may end up in the old list
+// and be lost, so the caller must block inserts while clearing
+func (s *ConcurrentSkipList) Clear() {
+	s.head.Store(newCnode("", nil, s.maxHeight))
+	s.size.Store(0)
+}
@@ -0,0 +1,60 @@
+package skiplist
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPut(t *testing.T) {
+	s := NewConcurrent(10)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				// every key is written by two workers
+				k := fmt.Sprintf("%04d", (w/2)*500+i)
+				s.Put(k, k)
+				s.Get(k)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if s.Size() != 2000 {
+		t.Errorf("size %d, want 2000", s.Size())
+	}
+	all := s.GetAll()
+	keys := make([]string, len(all))
+	for i, data := range all {
+		keys[i] = data.(string)
+	}
+	if len(keys) != 2000 || !sort.StringsAreSorted(keys) {
+		t.Errorf("GetAll returned %d keys, sorted %v", len(keys), sort.StringsAreSorted(keys))
+	}
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if data, found := s.Get(k); !found || data.(string) != k {
+			t.Errorf("%s not found", k)
+		}
+	}
+	if _, found := s.Get("x"); found {
+		t.Errorf("found non existing")
+	}
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	s := NewConcurrent(10)
+	s.Put("a", 1)
+	s.Put("a", 2)
+	if data, _ := s.Get("a"); data.(int) != 2 || s.Size() != 1 {
+		t.Errorf("update")
+	}
+	s.Clear()
+	if _, found := s.Get("a"); found || s.Size() != 0 {
+		t.Errorf("clear")
+	}
+}